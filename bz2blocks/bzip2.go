@@ -361,11 +361,15 @@ func (bz2 *reader) Close() (err error) {
 func (bz2 *readerAt) ReadAt(buf []byte, off int64) (n int, err error) {
 	bz2.m.Lock()
 	defer bz2.m.Unlock()
+	if bz2.setupErr != nil {
+		return 0, bz2.setupErr
+	}
 	startBitPos := int64(-1)
 	startBytePos := int64(-1)
 	for _, b := range bz2.blocks {
 		if b.OutBytePos <= off {
 			startBitPos = b.InBitPos
+			startBytePos = b.OutBytePos
 		}
 	}
 	if startBitPos == -1 {
@@ -373,6 +377,10 @@ func (bz2 *readerAt) ReadAt(buf []byte, off int64) (n int, err error) {
 	}
 
 	bz2.br = newBitReaderPos(bz2.ra, startBitPos)
+	// forget whatever block the last call was in
+	bz2.preRLE, bz2.preRLEUsed = nil, 0
+	bz2.lastByte, bz2.byteRepeats, bz2.repeats = -1, 0, 0
+	bz2.eof = false
 
 	// discard the beginning of the block
 	remaining := off - startBytePos
@@ -389,15 +397,19 @@ func (bz2 *readerAt) ReadAt(buf []byte, off int64) (n int, err error) {
 			err = brErr
 		}
 		if err != nil {
-			return
+			return 0, err
 		}
 	}
 
-	// TODO: actually have to read as many times as it takes to fill the block
-	n, err = bz2.read(buf)
-	brErr := bz2.br.Err()
-	if brErr != nil {
-		err = brErr
+	// read is done when a block runs out, so go until buf's full
+	n = 0
+	for n < len(buf) && err == nil {
+		var nn int
+		nn, err = bz2.read(buf[n:])
+		n += nn
+		if brErr := bz2.br.Err(); brErr != nil {
+			err = brErr
+		}
 	}
 	return
 }
@@ -701,16 +713,20 @@ type blockBoundary struct {
 type blockList []blockBoundary
 
 func (bz2 *reader) writeIndex(w io.Writer) (err error) {
+	return writeIndex(w, bz2.blockSize, bz2.blocks)
+}
+
+func writeIndex(w io.Writer, blockSize int, blocks blockList) (err error) {
 	// writing the block count
-	err = binary.Write(w, binary.BigEndian, uint64(len(bz2.blocks)))
+	err = binary.Write(w, binary.BigEndian, uint64(len(blocks)))
 	if err != nil {
 		return err
 	}
-	err = binary.Write(w, binary.BigEndian, uint64(bz2.blockSize))
+	err = binary.Write(w, binary.BigEndian, uint64(blockSize))
 	if err != nil {
 		return err
 	}
-	return binary.Write(w, binary.BigEndian, bz2.blocks)
+	return binary.Write(w, binary.BigEndian, blocks)
 }
 
 func (bz2 *readerBase) readIndex(r io.Reader) (err error) {
@@ -0,0 +1,183 @@
+// Public domain, dltp contributors, 2026
+
+package bz2blocks
+
+// Suffix array construction by induced sorting (SA-IS), from Nong, Zhang, and
+// Chan, "Two Efficient Algorithms for Linear Time Suffix Array Construction."
+// It takes linear time however repetitive the input is, which matters for
+// dumps, where a page's revisions are mostly the same text over and over.
+
+// suffixArray fills sa with the suffix array of s, whose values are all in
+// [0, k) and whose last value must be a unique 0 (a sentinel).
+func suffixArray(s []int32, sa []int32, k int) {
+	n := len(s)
+	sType := make([]bool, n) // true for S-type suffixes, false for L-type
+	sType[n-1] = true
+	for i := n - 2; i >= 0; i-- {
+		sType[i] = s[i] < s[i+1] || (s[i] == s[i+1] && sType[i+1])
+	}
+	isLMS := func(i int) bool {
+		return i > 0 && sType[i] && !sType[i-1]
+	}
+	bkt := make([]int32, k)
+
+	// stage 1: sort the LMS substrings
+	bucketEnds(s, bkt)
+	for i := range sa {
+		sa[i] = -1
+	}
+	for i := 1; i < n; i++ {
+		if isLMS(i) {
+			bkt[s[i]]--
+			sa[bkt[s[i]]] = int32(i)
+		}
+	}
+	induce(s, sa, sType, bkt)
+
+	// compact the sorted LMS substrings into the start of sa
+	n1 := 0
+	for i := 0; i < n; i++ {
+		if isLMS(int(sa[i])) {
+			sa[n1] = sa[i]
+			n1++
+		}
+	}
+
+	// name them, with equal substrings getting equal names
+	for i := n1; i < n; i++ {
+		sa[i] = -1
+	}
+	name, prev := 0, -1
+	for i := 0; i < n1; i++ {
+		pos := int(sa[i])
+		diff := false
+		for d := 0; d < n; d++ {
+			if prev == -1 || s[pos+d] != s[prev+d] || sType[pos+d] != sType[prev+d] {
+				diff = true
+				break
+			} else if d > 0 && (isLMS(pos+d) || isLMS(prev+d)) {
+				break
+			}
+		}
+		if diff {
+			name++
+			prev = pos
+		}
+		sa[n1+pos/2] = int32(name - 1) // LMS positions are at least 2 apart
+	}
+	for i, j := n-1, n-1; i >= n1; i-- {
+		if sa[i] >= 0 {
+			sa[j] = sa[i]
+			j--
+		}
+	}
+
+	// stage 2: sort the reduced string, recursing if names repeat
+	s1, sa1 := sa[n-n1:], sa[:n1]
+	if name < n1 {
+		suffixArray(s1, sa1, name)
+	} else {
+		for i := 0; i < n1; i++ {
+			sa1[s1[i]] = int32(i)
+		}
+	}
+
+	// stage 3: induce the full suffix array from the sorted LMS suffixes
+	bucketEnds(s, bkt)
+	for i, j := 1, 0; i < n; i++ {
+		if isLMS(i) {
+			s1[j] = int32(i)
+			j++
+		}
+	}
+	for i := 0; i < n1; i++ {
+		sa1[i] = s1[sa1[i]]
+	}
+	for i := n1; i < n; i++ {
+		sa[i] = -1
+	}
+	for i := n1 - 1; i >= 0; i-- {
+		j := sa[i]
+		sa[i] = -1
+		bkt[s[j]]--
+		sa[bkt[s[j]]] = j
+	}
+	induce(s, sa, sType, bkt)
+}
+
+func bucketStarts(s []int32, bkt []int32) {
+	counts(s, bkt)
+	sum := int32(0)
+	for i, c := range bkt {
+		bkt[i] = sum
+		sum += c
+	}
+}
+
+func bucketEnds(s []int32, bkt []int32) {
+	counts(s, bkt)
+	sum := int32(0)
+	for i, c := range bkt {
+		sum += c
+		bkt[i] = sum
+	}
+}
+
+func counts(s []int32, bkt []int32) {
+	for i := range bkt {
+		bkt[i] = 0
+	}
+	for _, c := range s {
+		bkt[c]++
+	}
+}
+
+// induce sorts the L-type suffixes from the S-type ones in sa, then the
+// S-type from the L-type
+func induce(s []int32, sa []int32, sType []bool, bkt []int32) {
+	bucketStarts(s, bkt)
+	for i := 0; i < len(sa); i++ {
+		j := sa[i] - 1
+		if sa[i] > 0 && !sType[j] {
+			sa[bkt[s[j]]] = j
+			bkt[s[j]]++
+		}
+	}
+	bucketEnds(s, bkt)
+	for i := len(sa) - 1; i >= 0; i-- {
+		j := sa[i] - 1
+		if sa[i] > 0 && sType[j] {
+			bkt[s[j]]--
+			sa[bkt[s[j]]] = j
+		}
+	}
+}
+
+// bwt does the Burrows-Wheeler transform bzip2 wants, sorting all the
+// rotations of block (not suffixes, so no end marker). It returns the last
+// column and where the unrotated block ended up.
+func bwt(block []byte) (last []byte, origPtr int) {
+	n := len(block)
+	// suffixes of the block twice over start with the rotations, and
+	// rotations that differ do so in the first n bytes
+	s := make([]int32, 2*n+1)
+	for i, c := range block {
+		s[i] = int32(c) + 1
+		s[i+n] = int32(c) + 1
+	}
+	sa := make([]int32, len(s))
+	suffixArray(s, sa, 257)
+	last = make([]byte, 0, n)
+	for _, p := range sa {
+		if int(p) >= n {
+			continue
+		}
+		if p == 0 {
+			origPtr = len(last)
+			last = append(last, block[n-1])
+		} else {
+			last = append(last, block[p-1])
+		}
+	}
+	return last, origPtr
+}
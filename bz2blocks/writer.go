@@ -0,0 +1,544 @@
+// Public domain, dltp contributors, 2026
+
+package bz2blocks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+/*
+
+PARALLEL BZIP2 COMPRESSION
+
+Writer is a bzip2 compressor for when there's no bzip2 or lbzip2 program
+around. Like lbzip2, it compresses several blocks at once on different cores,
+then stitches them together in order; bzip2 blocks aren't byte-aligned, so the
+stitching happens a bit at a time.
+
+Each block goes through the same steps as in bzip2 proper: run-length encoding
+runs of 4-255 equal bytes, the Burrows-Wheeler transform (via a suffix array;
+see sais.go), move-to-front coding with RUNA/RUNB for runs of zeros, and
+Huffman coding with two to six tables, switching every 50 symbols. We pick the
+tables the way bzip2 does (start from an even split of the symbols, then
+refine a few times), so the output's close to bzip2's size.
+
+NewIndexingWriter also records where each block starts, and on Close writes
+the same index NewIndexingReader would, ready for NewReaderAt.
+
+*/
+
+const (
+	maxCodeLen = 17 // bzip2 allows 20, but it doesn't go over 17 itself
+	groupSize  = 50 // symbols per Huffman table selector
+	iterations = 4  // passes refining the tables
+)
+
+// bzip2's CRC is the big-endian CRC-32, which hash/crc32 doesn't do
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&(1<<31) != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return
+}()
+
+type bitWriter struct {
+	out   []byte
+	bits  uint64 // pending bits, at the bottom
+	nBits uint
+}
+
+// WriteBits writes the bottom n bits of v, n <= 32.
+func (bw *bitWriter) WriteBits(n uint, v uint64) {
+	bw.bits = bw.bits<<n | v&(1<<n-1)
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.nBits -= 8
+		bw.out = append(bw.out, byte(bw.bits>>bw.nBits))
+	}
+}
+
+// WriteFrom appends everything written to b.
+func (bw *bitWriter) WriteFrom(b *bitWriter) {
+	if bw.nBits == 0 {
+		bw.out = append(bw.out, b.out...)
+	} else {
+		for _, c := range b.out {
+			bw.WriteBits(8, uint64(c))
+		}
+	}
+	bw.WriteBits(b.nBits, b.bits)
+}
+
+type Writer struct {
+	out      io.Writer
+	level    int
+	maxBlock int       // most RLE'd bytes in a block
+	indexOut io.Writer // or nil
+
+	block   []byte // RLE'd contents of the block we're filling
+	size    int64  // uncompressed bytes in it
+	crc     uint32
+	runByte byte
+	runLen  int // how many runByte we've seen and haven't put in block
+
+	queue  chan chan compressedBlock // in order
+	done   chan bool
+	closed bool
+
+	// only writeBlocks touches these until it's done
+	bw          bitWriter
+	written     int64 // bytes of output
+	n           int64 // bytes of input
+	combinedCRC uint32
+	blocks      blockList
+
+	mu  sync.Mutex
+	err error // from writing out
+}
+
+type compressedBlock struct {
+	bits *bitWriter
+	crc  uint32
+	size int64 // uncompressed
+}
+
+// NewWriter starts a bzip2 stream on out at level 1-9 (100KB-900KB blocks).
+func NewWriter(out io.Writer, level int) (*Writer, error) {
+	return NewIndexingWriter(out, level, nil)
+}
+
+// NewIndexingWriter is NewWriter, plus on Close it writes an index of the
+// blocks to indexOut for NewReaderAt.
+func NewIndexingWriter(out io.Writer, level int, indexOut io.Writer) (*Writer, error) {
+	if level < 1 || level > 9 {
+		return nil, fmt.Errorf("bzip2 level %d not in 1-9", level)
+	}
+	w := &Writer{
+		out:      out,
+		level:    level,
+		maxBlock: 100000*level - 19, // bzip2's own limit
+		indexOut: indexOut,
+		crc:      0xffffffff,
+		queue:    make(chan chan compressedBlock, runtime.GOMAXPROCS(0)),
+		done:     make(chan bool),
+	}
+	w.bw.WriteBits(24, 'B'<<16|'Z'<<8|'h')
+	w.bw.WriteBits(8, uint64('0'+level))
+	go w.writeBlocks()
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, errors.New("write to closed bz2blocks.Writer")
+	}
+	for _, c := range p {
+		if c == w.runByte && w.runLen > 0 && w.runLen < 255 {
+			w.runLen++
+			continue
+		}
+		w.flushRun()
+		w.runByte, w.runLen = c, 1
+	}
+	return len(p), w.error()
+}
+
+func (w *Writer) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// flushRun puts the current run in the block: up to four bytes as they are,
+// and if there were four, a count of how many more
+func (w *Writer) flushRun() {
+	if w.runLen == 0 {
+		return
+	}
+	if len(w.block)+5 > w.maxBlock {
+		w.compress()
+	}
+	if w.block == nil {
+		w.block = make([]byte, 0, w.maxBlock)
+	}
+	for i := 0; i < w.runLen && i < 4; i++ {
+		w.block = append(w.block, w.runByte)
+	}
+	if w.runLen >= 4 {
+		w.block = append(w.block, byte(w.runLen-4))
+	}
+	for i := 0; i < w.runLen; i++ {
+		w.crc = w.crc<<8 ^ crcTable[byte(w.crc>>24)^w.runByte]
+	}
+	w.size += int64(w.runLen)
+	w.runLen = 0
+}
+
+// compress sends the block off to be compressed in the background
+func (w *Writer) compress() {
+	block, crc, size := w.block, ^w.crc, w.size
+	w.block, w.crc, w.size = nil, 0xffffffff, 0
+	result := make(chan compressedBlock, 1)
+	w.queue <- result // blocks if we're too far ahead of the output
+	go func() {
+		result <- compressedBlock{compressBlock(block, crc), crc, size}
+	}()
+}
+
+func (w *Writer) bitPos() int64 {
+	return (w.written+int64(len(w.bw.out)))*8 + int64(w.bw.nBits)
+}
+
+// flush writes out the whole bytes in bw
+func (w *Writer) flush() {
+	if w.error() == nil {
+		_, err := w.out.Write(w.bw.out)
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+	}
+	w.written += int64(len(w.bw.out))
+	w.bw.out = w.bw.out[:0]
+}
+
+func (w *Writer) writeBlocks() {
+	for result := range w.queue {
+		b := <-result
+		w.blocks = append(w.blocks, blockBoundary{InBitPos: w.bitPos(), OutBytePos: w.n})
+		w.n += b.size
+		w.combinedCRC = (w.combinedCRC<<1 | w.combinedCRC>>31) ^ b.crc
+		w.bw.WriteFrom(b.bits)
+		w.flush()
+	}
+	w.done <- true
+}
+
+// Close writes the last block, the end of the stream, and the index if
+// there's one to write. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.error()
+	}
+	w.closed = true
+	w.flushRun()
+	if len(w.block) > 0 {
+		w.compress()
+	}
+	close(w.queue)
+	<-w.done
+	// the index has an entry for the end, like NewIndexingReader's
+	w.blocks = append(w.blocks, blockBoundary{InBitPos: w.bitPos(), OutBytePos: w.n})
+	w.bw.WriteBits(24, bzip2FinalMagic>>24)
+	w.bw.WriteBits(24, bzip2FinalMagic&0xffffff)
+	w.bw.WriteBits(32, uint64(w.combinedCRC))
+	if w.bw.nBits > 0 {
+		w.bw.WriteBits(8-w.bw.nBits, 0)
+	}
+	w.flush()
+	if w.err != nil {
+		return w.err
+	}
+	if w.indexOut != nil {
+		w.err = writeIndex(w.indexOut, 100*1024*w.level, w.blocks)
+	}
+	return w.err
+}
+
+// compressBlock turns RLE'd bytes into a bzip2 block, magic number and all
+func compressBlock(block []byte, crc uint32) *bitWriter {
+	bw := &bitWriter{out: make([]byte, 0, len(block)/3)}
+	bw.WriteBits(24, bzip2BlockMagic>>24)
+	bw.WriteBits(24, bzip2BlockMagic&0xffffff)
+	bw.WriteBits(32, uint64(crc))
+	bw.WriteBits(1, 0) // not randomized
+	last, origPtr := bwt(block)
+	bw.WriteBits(24, uint64(origPtr))
+
+	// which bytes are used, as a two-level 16x16 bitmap
+	var inUse [256]bool
+	for _, c := range last {
+		inUse[c] = true
+	}
+	var rangesUsed [16]uint64
+	numUsed := 0
+	for c, used := range inUse {
+		if used {
+			rangesUsed[c/16] |= 1 << uint(15-c%16)
+			numUsed++
+		}
+	}
+	ranges := uint64(0)
+	for i, bits := range rangesUsed {
+		if bits != 0 {
+			ranges |= 1 << uint(15-i)
+		}
+	}
+	bw.WriteBits(16, ranges)
+	for _, bits := range rangesUsed {
+		if bits != 0 {
+			bw.WriteBits(16, bits)
+		}
+	}
+
+	symbols := moveToFront(last, &inUse, numUsed)
+	alphaSize := numUsed + 2 // plus RUNA/RUNB, minus zero, plus end of block
+	numGroups := (len(symbols) + groupSize - 1) / groupSize
+	lengths, selectors := chooseTables(symbols, alphaSize)
+
+	bw.WriteBits(3, uint64(len(lengths)))
+	bw.WriteBits(15, uint64(numGroups))
+	// the selectors are move-to-front coded too, then written in unary
+	order := []uint8{0, 1, 2, 3, 4, 5}
+	for _, sel := range selectors {
+		j := 0
+		for order[j] != sel {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = sel
+		bw.WriteBits(uint(j+1), (1<<uint(j)-1)<<1)
+	}
+
+	// code lengths, as deltas from a 5-bit start
+	codes := make([][]uint32, len(lengths))
+	for t, tableLengths := range lengths {
+		cur := tableLengths[0]
+		bw.WriteBits(5, uint64(cur))
+		for _, l := range tableLengths {
+			for ; cur < l; cur++ {
+				bw.WriteBits(2, 2)
+			}
+			for ; cur > l; cur-- {
+				bw.WriteBits(2, 3)
+			}
+			bw.WriteBits(1, 0)
+		}
+		codes[t] = canonicalCodes(tableLengths)
+	}
+
+	for i, s := range symbols {
+		t := selectors[i/groupSize]
+		bw.WriteBits(uint(lengths[t][s]), uint64(codes[t][s]))
+	}
+	return bw
+}
+
+// moveToFront codes the BWT output as indexes into a move-to-front list of
+// the bytes used, with runs of zeros written as RUNA (0) and RUNB (1) digits
+// and other indexes i as i+1. The last symbol is the end of block.
+func moveToFront(last []byte, inUse *[256]bool, numUsed int) []uint16 {
+	var order [256]byte
+	n := 0
+	for c, used := range inUse {
+		if used {
+			order[n] = byte(c)
+			n++
+		}
+	}
+	symbols := make([]uint16, 0, len(last)/2+1)
+	zeros := 0
+	flushZeros := func() {
+		// bijective base 2, least significant digit first
+		for r := zeros - 1; zeros > 0; r = (r - 2) / 2 {
+			symbols = append(symbols, uint16(r&1))
+			if r < 2 {
+				break
+			}
+		}
+		zeros = 0
+	}
+	for _, c := range last {
+		if order[0] == c {
+			zeros++
+			continue
+		}
+		flushZeros()
+		j := 1
+		for order[j] != c {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = c
+		symbols = append(symbols, uint16(j+1))
+	}
+	flushZeros()
+	return append(symbols, uint16(numUsed+1))
+}
+
+// chooseTables picks Huffman code lengths for two to six tables and which
+// table to use for each group of symbols, as bzip2 does
+func chooseTables(symbols []uint16, alphaSize int) (lengths [][]uint8, selectors []uint8) {
+	numTables := 6
+	switch {
+	case len(symbols) < 200:
+		numTables = 2
+	case len(symbols) < 600:
+		numTables = 3
+	case len(symbols) < 1200:
+		numTables = 4
+	case len(symbols) < 2400:
+		numTables = 5
+	}
+	freqs := make([]int, alphaSize)
+	for _, s := range symbols {
+		freqs[s]++
+	}
+
+	// start with each table cheap for its own slice of the alphabet, with
+	// about the same number of symbols in each slice
+	lengths = make([][]uint8, numTables)
+	remaining, start := len(symbols), 0
+	for part := numTables; part > 0; part-- {
+		target, sum := remaining/part, 0
+		end := start - 1
+		for sum < target && end < alphaSize-1 {
+			end++
+			sum += freqs[end]
+		}
+		if end > start && part != numTables && part != 1 && (numTables-part)%2 == 1 {
+			sum -= freqs[end]
+			end--
+		}
+		t := make([]uint8, alphaSize)
+		for s := range t {
+			if s < start || s > end {
+				t[s] = 15
+			}
+		}
+		lengths[part-1] = t
+		remaining -= sum
+		start = end + 1
+	}
+
+	// then assign groups to the tables they're cheapest with, and refit the
+	// tables to their groups
+	selectors = make([]uint8, (len(symbols)+groupSize-1)/groupSize)
+	tableFreqs := make([][]int, numTables)
+	for i := range tableFreqs {
+		tableFreqs[i] = make([]int, alphaSize)
+	}
+	for iter := 0; iter < iterations; iter++ {
+		for _, f := range tableFreqs {
+			for s := range f {
+				f[s] = 0
+			}
+		}
+		for g := range selectors {
+			group := symbols[g*groupSize:]
+			if len(group) > groupSize {
+				group = group[:groupSize]
+			}
+			best, bestCost := 0, -1
+			for t, tableLengths := range lengths {
+				cost := 0
+				for _, s := range group {
+					cost += int(tableLengths[s])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[g] = uint8(best)
+			for _, s := range group {
+				tableFreqs[best][s]++
+			}
+		}
+		for t := range lengths {
+			lengths[t] = codeLengths(tableFreqs[t], maxCodeLen)
+		}
+	}
+	return lengths, selectors
+}
+
+// codeLengths makes Huffman code lengths no longer than maxLen; every symbol
+// gets a code, even unused ones, since bzip2 wants a complete code. If the
+// code's too long, we flatten the frequencies and try again, like bzip2.
+func codeLengths(freqs []int, maxLen int) []uint8 {
+	weights := make([]int, len(freqs))
+	for s, f := range freqs {
+		weights[s] = f
+		if f == 0 {
+			weights[s] = 1
+		}
+	}
+	for {
+		lengths, longest := huffmanLengths(weights)
+		if longest <= maxLen {
+			return lengths
+		}
+		for s := range weights {
+			weights[s] = weights[s]/2 + 1
+		}
+	}
+}
+
+// huffmanLengths builds the tree with two queues, one of leaves sorted by
+// weight and one of internal nodes, which come out already sorted
+func huffmanLengths(weights []int) (lengths []uint8, longest int) {
+	n := len(weights)
+	leaves := make([]int, n)
+	for s := range leaves {
+		leaves[s] = s
+	}
+	sort.Slice(leaves, func(i, j int) bool { return weights[leaves[i]] < weights[leaves[j]] })
+	weight := make([]int, 2*n-1)
+	parent := make([]int, 2*n-1)
+	copy(weight, weights)
+	leaf, node, next := 0, n, n
+	lightest := func() int {
+		if leaf < n && (node == next || weight[leaves[leaf]] <= weight[node]) {
+			leaf++
+			return leaves[leaf-1]
+		}
+		node++
+		return node - 1
+	}
+	for ; next < 2*n-1; next++ {
+		a, b := lightest(), lightest()
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+	}
+	// parents come after their children, so go from the root down
+	depth := weight // reuse
+	depth[2*n-2] = 0
+	for i := 2*n - 3; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+	}
+	lengths = make([]uint8, n)
+	for s := range lengths {
+		lengths[s] = uint8(depth[s])
+		if depth[s] > longest {
+			longest = depth[s]
+		}
+	}
+	return lengths, longest
+}
+
+// canonicalCodes assigns codes in order of length, then symbol
+func canonicalCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	code := uint32(0)
+	for l := uint8(1); l <= maxCodeLen; l++ {
+		for s, sl := range lengths {
+			if sl == l {
+				codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
@@ -0,0 +1,77 @@
+// Public domain, dltp contributors, 2026
+
+package bz2blocks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1)) // the same inputs every run
+	text := &bytes.Buffer{}
+	for i := 0; text.Len() < 300000; i++ {
+		fmt.Fprintf(text, "<page><id>%d</id><text>%d</text></page>\n", i, i*i)
+		if i%1000 == 0 { // runs for the RLE step, some longer than it takes
+			text.Write(bytes.Repeat([]byte{'='}, i/10))
+		}
+		if i%1500 == 0 {
+			junk := make([]byte, 2000)
+			rng.Read(junk)
+			text.Write(junk)
+		}
+	}
+	want := text.Bytes()
+
+	compressed, index := &bytes.Buffer{}, &bytes.Buffer{}
+	w, err := NewIndexingWriter(compressed, 1, index) // 100KB blocks
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(want[:1234])
+	w.Write(want[1234:])
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(compressed.Bytes())))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("decode: %v, content matches: %v", err, bytes.Equal(got, want))
+	}
+	got, err = ioutil.ReadAll(NewParallelReader(bytes.NewReader(compressed.Bytes())))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("parallel decode: %v, content matches: %v", err, bytes.Equal(got, want))
+	}
+
+	r := NewReaderAt(bytes.NewReader(compressed.Bytes()), index)
+	for i := 0; i < 50; i++ {
+		off := rng.Intn(len(want))
+		p := make([]byte, rng.Intn(300000))
+		n, err := r.ReadAt(p, int64(off))
+		wantN := len(want) - off
+		if wantN > len(p) {
+			wantN = len(p)
+		}
+		if n != wantN || !bytes.Equal(p[:n], want[off:off+n]) {
+			t.Fatalf("ReadAt(%d bytes, %d): got %d bytes (%v), want %d", len(p), off, n, err, wantN)
+		}
+		if (err == io.EOF) != (n < len(p)) {
+			t.Fatalf("ReadAt(%d bytes, %d): err %v with %d bytes", len(p), off, err, n)
+		}
+	}
+
+	// nothing at all still makes a valid stream
+	compressed.Reset()
+	w, _ = NewWriter(compressed, 9)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(NewReader(compressed))
+	if err != nil || len(got) > 0 {
+		t.Fatalf("empty stream: got %d bytes, %v", len(got), err)
+	}
+}
@@ -3,9 +3,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -17,6 +20,8 @@ import (
 	"strings"
 
 	"github.com/twotwotwo/dltp/dpfile"
+	"github.com/twotwotwo/dltp/jsonl"
+	"github.com/twotwotwo/dltp/scan"
 	"github.com/twotwotwo/dltp/stream"
 	"github.com/twotwotwo/dltp/zip"
 
@@ -39,7 +44,7 @@ func WriteDiffPack(out io.WriteCloser, workingDir *os.File, inNames []string) {
 		outName := zip.UnzippedName(baseName) + OutSuffix
 
 		if *compression != "" {
-			outName += "." + *compression
+			outName += "." + zip.FormatOf(*compression)
 		}
 		outFile, err := os.Create(path.Join(workingDir.Name(), outName))
 		if err != nil {
@@ -53,7 +58,7 @@ func WriteDiffPack(out io.WriteCloser, workingDir *os.File, inNames []string) {
 		}
 	}
 	// newwriter
-	w := dpfile.NewWriter(out, workingDir, inNames, *lastRev, limitToNS, ns, *cutMeta)
+	w := dpfile.NewWriter(out, workingDir, inNames, cutOpts)
 	for w.WriteSegment() {
 	}
 	w.Close()
@@ -68,6 +73,11 @@ func ReadDiffPack(dp io.Reader, workingDir *os.File, streaming bool) {
 	}
 	r := dpfile.NewReader(dp, workingDir, streaming)
 	r.ChangeDump = *changeDump
+	if *format == "jsonl" {
+		r.ConvertOutput(func(w io.Writer) io.WriteCloser {
+			return jsonl.NewWriter(w, 0)
+		}, ".jsonl")
+	}
 	// readsegment while we can
 	for r.ReadSegment() {
 	}
@@ -75,65 +85,58 @@ func ReadDiffPack(dp io.Reader, workingDir *os.File, streaming bool) {
 	r.Close()
 }
 
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// wraps output in a converter if -format asks for one
+func convertOutput(out io.Writer) io.WriteCloser {
+	if *format == "jsonl" {
+		return jsonl.NewWriter(out, cutOpts.MaxSegmentSize)
+	}
+	return nopCloser{out}
+}
+
+// stdinReader is stdin, decompressed if it looks compressed
+func stdinReader() io.Reader {
+	r, _, err := zip.NewSniffingReader(os.Stdin)
+	if err != nil {
+		quitWith("can't read stdin: %s", err)
+	}
+	return r
+}
+
 func CutStdinToStdout() {
-	r := chunk.NewSegmentReader(os.Stdin, 0, *lastRev, limitToNS, ns, *cutMeta)
+	r := chunk.NewSegmentReader(stdinReader(), 0, cutOpts)
+	out := convertOutput(os.Stdout)
+	warned := false
 	for {
 		text, _, _, err := r.ReadNext()
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
+		if _, ok := err.(*chunk.UnsortedError); ok { // fine when cutting, but say so
+			if !warned {
+				fmt.Fprintln(os.Stderr, "Warning:", err, "(output will be out of order too; -sort fixes that)")
+				warned = true
 			}
-			break
+			err = nil
 		}
-		_, err = os.Stdout.Write(text)
-		if err != nil {
+		if err != nil && err != io.EOF {
 			panic(err)
 		}
-	}
-}
-
-func Merge(in []io.Reader, out io.Writer) {
-	readers := make([]*chunk.SegmentReader, len(in))
-	for i, f := range in {
-		readers[i] = chunk.NewSegmentReader(f, int64(i), *lastRev, limitToNS, ns, *cutMeta)
-	}
-	lastKey := chunk.BeforeStart
-	keys := make([]chunk.SegmentKey, len(in))
-	for i, _ := range keys {
-		keys[i] = lastKey
-	}
-	text := make([][]byte, len(in))
-	for lastKey != chunk.PastEndKey {
-		// advance each past lastKey
-		for i, r := range readers {
-			for keys[i] <= lastKey {
-				txt, key, _, err := r.ReadNext()
-				text[i], keys[i] = txt, key
-				if err != nil {
-					if err != io.EOF {
-						panic(err)
-					}
-				}
-			}
+		// the last segment is the </mediawiki> trailer, so write it before quitting
+		_, writeErr := out.Write(text)
+		if writeErr != nil {
+			panic(writeErr)
 		}
-		// look for lowest value
-		lastKey = chunk.PastEndKey
-		for _, key := range keys {
-			if key < lastKey {
-				lastKey = key
-			}
-		}
-		// print the text for leftmost instance of it
-		for i, key := range keys {
-			if key == lastKey {
-				_, err := out.Write(text[i])
-				if err != nil {
-					panic(err)
-				}
-				break
-			}
+		if err == io.EOF {
+			break
 		}
 	}
+	err := out.Close()
+	if err != nil {
+		panic(err)
+	}
 }
 
 /* COMMAND-LINE HANDLING */
@@ -143,20 +146,44 @@ var memprofile = flag.String("memprofile", "", "write mem profile to file")
 var useStdout = flag.Bool("c", false, "write to stdout even if unpacking file")
 var useFile = flag.Bool("f", false, "write to file even if unpacking stdin")
 var lastRev = flag.Bool("lastrev", false, "remove all but last rev in incr XML")
-var nsString = flag.String("ns", "", "limit to pages in given <ns>")
+var nsString = flag.String("ns", "", "limit to pages in given <ns> (number or name, like 0 or Talk)")
 var cutMeta = flag.Bool("cutmeta", false, "cut <contributor>/<comment>/<minor>")
+var noText = flag.Bool("notext", false, "empty out <text>, leaving metadata like a stub dump (same as -strip textbody)")
+var anonymize = flag.String("anonymize", "", "replace contributor names, IPs, and IDs with pseudonyms made with this key (or @file to read the key from file)")
+var noRedirects = flag.Bool("noredirects", false, "skip redirect pages")
+var minSize = flag.String("minsize", "", "skip pages whose last revision's text is smaller than this (e.g., 1K)")
+var sample = flag.Float64("sample", 0, "keep only about this fraction of pages (e.g., 0.01), picked by hashing page IDs")
+var seed = flag.Uint64("seed", 0, "with -sample, which sample to take")
+var maxSize = flag.String("maxsize", "", "skip pages whose last revision's text is bigger than this (e.g., 1MB)")
+var stripList = flag.String("strip", "", "cut listed elements (e.g., sha1,model,format,parentid,textattrs)")
 var cut = flag.Bool("cut", false, "just output a cut down stdin (don't pack)")
 var merge = flag.Bool("merge", false, "merge files listed on command line (newest first) to stdout")
 var debug = flag.Bool("debug", false, "on error, show ugly but useful debug info")
-var compression = flag.String("zip", "auto", "set output compression (bz2, gz, lzo, none)")
+var compression = flag.String("zip", "auto", "set output compression (bz2, gz, zst, xz, lzo, none), optionally with a level, like bz2:9, xz:9e, or zst:19,long")
 var changeDump = flag.Bool("changedump", false, "unpack only changed pages + dump preamble/close tag")
+var spill = flag.String("spill", "", "decompress compressed references into temp files in this directory, so they can be read in any order")
+var refCache = flag.String("refcache", "", "like -spill, but keep the decompressed copies in this directory and reuse them (a copy is matched to its compressed file by path, size, and modification time, not by hashing its contents; exiting waits for unfinished copies)")
+var rewind = flag.String("rewind", "", "when unpacking, how far back to remember compressed sources for rereading (default 16MB)")
 
-var limitToNS = false
-var ns = 0
+var format = flag.String("format", "xml", "output format for -cut, -merge, and unpacking (xml or jsonl)")
+var deletionsList = flag.String("deletions", "", "when merging, drop pages listed in these files (page IDs or logging dumps, comma-separated)")
+var element = flag.String("element", "page", "element each page is in (logitem for logging dumps, doc for abstracts)")
+var keyTag = flag.String("key", "id", "tag holding each page's ID, or # to number them in order")
+var maxSegment = flag.String("maxsegment", "", "split pages bigger than this (e.g., 64MB) into parts, to bound memory use")
+var maxBuffer = flag.String("maxbuffer", "", "fail on a page needing more than this much memory (default: 512MB, or -maxsegment plus 256MB; 0 for no limit)")
+
+var cutOpts chunk.Options
+var deletions *chunk.DeletionList
+
+const defaultMaxBuffer = 512 << 20
 
 func recoverAndPrintError() {
 	if r := recover(); r != nil {
 		fmt.Println("Error:", r)
+		tooLarge := (*scan.TooLargeError)(nil)
+		if err, ok := r.(error); ok && errors.As(err, &tooLarge) {
+			fmt.Println("(if pages really are that big, use -maxsegment, or raise -maxbuffer)")
+		}
 		os.Exit(255)
 	}
 }
@@ -166,11 +193,140 @@ func quitWith(format string, a ...interface{}) {
 	os.Exit(255)
 }
 
+// parses sizes like 2GB or 64M; plain numbers are bytes
+func parseSize(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	str = strings.TrimSuffix(str, "B")
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(str, suffix) {
+			str = str[:len(str)-1]
+			multiplier = 1 << (10 * uint(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("can't understand size '%s'", str)
+	}
+	return n * multiplier, nil
+}
+
+// parse the options shared by -cut, -merge, and packing
+func parseCutOptions() {
+	if strings.ContainsAny(*element, "<>/ ") || strings.ContainsAny(*keyTag, "<>/ ") {
+		quitWith("-element and -key take bare tag names, like logitem")
+	}
+	cutOpts.Element, cutOpts.KeyTag = *element, *keyTag
+	if *element != "page" {
+		if *nsString != "" {
+			quitWith("-ns only works with <page>s")
+		}
+		if *format != "xml" {
+			quitWith("-format jsonl only works with <page>s")
+		}
+	}
+	cutOpts.LastRevOnly = *lastRev
+	if *nsString != "" {
+		cutOpts.LimitToNS = true
+		var err error
+		cutOpts.NS, err = strconv.Atoi(*nsString)
+		if err != nil { // a name; look it up once we've read <siteinfo>
+			cutOpts.NSName = *nsString
+		}
+	}
+	names := []string(nil)
+	if *cutMeta {
+		names = append(names, chunk.CutMetaNames...)
+	}
+	if *noText {
+		names = append(names, "textbody")
+	}
+	if *stripList != "" {
+		names = append(names, strings.Split(*stripList, ",")...)
+	}
+	var err error
+	cutOpts.Strip, err = chunk.NewStrip(names)
+	if err != nil {
+		quitWith("%s", err)
+	}
+	if *anonymize != "" {
+		key := []byte(*anonymize)
+		if strings.HasPrefix(*anonymize, "@") {
+			var err error
+			key, err = ioutil.ReadFile((*anonymize)[1:])
+			if err != nil {
+				quitWith("can't read -anonymize key: %s", err)
+			}
+			key = bytes.TrimRight(key, "\r\n")
+		}
+		if len(key) == 0 {
+			quitWith("-anonymize key is empty")
+		}
+		cutOpts.Anonymize = chunk.NewAnonymizer(key)
+	}
+	cutOpts.NoRedirects = *noRedirects
+	if *sample < 0 || *sample > 1 {
+		quitWith("-sample takes a fraction from 0 to 1, like 0.01")
+	}
+	cutOpts.SampleRate, cutOpts.SampleSeed = *sample, *seed
+	cutOpts.MinSize = sizeFlag("minsize", *minSize)
+	cutOpts.MaxSize = sizeFlag("maxsize", *maxSize)
+	cutOpts.MaxSegmentSize = sizeFlag("maxsegment", *maxSegment)
+	cutOpts.MaxBuffer = sizeFlag("maxbuffer", *maxBuffer)
+	if *maxBuffer == "" {
+		// so a missing </page> stops us instead of reading the rest of the
+		// dump into memory
+		cutOpts.MaxBuffer = defaultMaxBuffer
+		if cutOpts.MaxSegmentSize > 0 {
+			// a part can run a bit past -maxsegment while we look for a place
+			// to split it, but not this far
+			cutOpts.MaxBuffer = cutOpts.MaxSegmentSize + 256<<20
+		}
+	}
+}
+
+// parseZipFlag checks -zip and leaves it "" for no compression or a spec
+// for zip.NewWriter
+func parseZipFlag() {
+	if *compression == "none" || *compression == "" {
+		*compression = ""
+		return
+	}
+	spec, err := zip.ParseSpec(*compression)
+	if err != nil {
+		quitWith("-zip: %s", err)
+	}
+	if !zip.CanWrite(spec.Format) {
+		quitWith("can't find (un)packer for ." + spec.Format)
+	}
+	if note := spec.Note(); note != "" {
+		fmt.Fprintln(os.Stderr, "Warning: -zip:", note)
+	}
+	*compression = spec.String()
+}
+
+// parseSize for a flag; 0 if it's not set
+func sizeFlag(name string, value string) int {
+	if value == "" {
+		return 0
+	}
+	size, err := parseSize(value)
+	if err != nil {
+		quitWith("-%s: %s", name, err)
+	}
+	return int(size)
+}
+
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Parse()
 	args := flag.Args()
 
+	if *format != "xml" && *format != "jsonl" {
+		quitWith("-format must be xml or jsonl")
+	}
+
 	if *debug {
 		c := make(chan os.Signal)
 		signal.Notify(c, os.Interrupt)
@@ -182,31 +338,76 @@ func main() {
 		defer recoverAndPrintError()
 	}
 
+	zip.SpillDir, zip.CacheDir = *spill, *refCache
+
 	if *merge {
-		if *useStdout || *useFile || *changeDump {
-			quitWith("only -lastrev, -ns, and -cutmeta work with -merge")
+		if *useStdout || *useFile || *changeDump || *splitCount != 0 || *splitSize != "" {
+			quitWith("only cutting options (-lastrev, -ns, -strip, etc.) work with -merge")
 		}
-		if *nsString != "" {
-			limitToNS = true
-			var err error
-			ns, err = strconv.Atoi(*nsString)
-			if err != nil {
-				quitWith("ns must be an integer")
-			}
+		if *maxOpen < 2 {
+			quitWith("-maxopen must be at least 2")
+		}
+		if *keyTag == chunk.OrdinalKey {
+			quitWith("-key '#' numbers items by position, so they can't be matched up for -merge")
+		}
+		parseCutOptions()
+	} else if *deletionsList != "" || *newest {
+		quitWith("-deletions and -newest only work with -merge")
+	} else if *splitCount != 0 || *splitSize != "" {
+		if *useStdout || *useFile || *changeDump || *cut {
+			quitWith("only cutting options (-lastrev, -ns, -strip, etc.) and -zip work with -split")
+		}
+		if *splitCount != 0 && *splitSize != "" {
+			quitWith("use either -split or -split-size, not both")
+		}
+		if *splitCount < 0 {
+			quitWith("-split needs a number of files")
+		}
+		if *splitCount != 0 && len(args) != 1 {
+			quitWith("-split needs to be given a file, so it can tell how far through it is")
+		}
+		if len(args) > 1 {
+			quitWith("-split and -split-size only take one file (or stdin)")
+		}
+		if *format != "xml" {
+			quitWith("-format only used with -cut, -merge, and unpacking")
+		}
+		if *compression != "auto" {
+			parseZipFlag()
+		}
+		parseCutOptions()
+	} else if *info {
+		if *cut || *sortDump || *useStdout || *useFile || *changeDump {
+			quitWith("-info only prints a dump's <siteinfo>; leave out other modes like -cut")
 		}
+		if len(args) > 1 {
+			quitWith("-info takes one dump (or stdin)")
+		}
+	} else if *sortDump {
+		if *useStdout || *useFile || *changeDump || *cut {
+			quitWith("only cutting options (-lastrev, -ns, -strip, etc.) work with -sort")
+		}
+		if len(args) > 0 {
+			quitWith("-sort only streams from stdin to stdout")
+		}
+		if *maxOpen < 2 {
+			quitWith("-maxopen must be at least 2")
+		}
+		parseCutOptions()
 	} else if *cut {
 		if *useStdout || *useFile || *changeDump {
-			quitWith("only -lastrev, -ns, and -cutmeta work with -cut")
+			quitWith("only cutting options (-lastrev, -ns, -strip, etc.) work with -cut")
 		}
 		if *merge {
 			quitWith("leave out -cut when using -merge")
 		}
-		if !(*lastRev || *cutMeta || *nsString != "") {
-			quitWith("use some of -lastrev, -ns, and -cutmeta with -cut")
+		if !(*lastRev || *cutMeta || *noText || *anonymize != "" || *nsString != "" || *stripList != "" || *noRedirects || *minSize != "" || *maxSize != "" || *sample != 0) {
+			quitWith("use some of -lastrev, -ns, -cutmeta, -notext, -anonymize, -strip, -noredirects, -minsize, -maxsize, and -sample with -cut")
 		}
 		if len(args) > 0 {
 			quitWith("-cut only streams from stdin to stdout")
 		}
+		parseCutOptions()
 	} else if len(args) < 2 { // validate other args as if unpacking
 		if *compression != "auto" {
 			quitWith("compression options only work when packing")
@@ -214,48 +415,48 @@ func main() {
 		if *useFile && *useStdout {
 			quitWith("can't write both to stdin and to file")
 		}
+		if *rewind != "" {
+			stream.DefaultRewind = sizeFlag("rewind", *rewind)
+		}
 		if *lastRev {
 			quitWith("-lastrev only used when packing")
 		}
 		if *nsString != "" {
 			quitWith("-ns only used when packing")
 		}
+		if *cutMeta || *noText || *stripList != "" || *anonymize != "" {
+			quitWith("-cutmeta, -notext, -strip, and -anonymize only used when packing")
+		}
+		if *noRedirects || *minSize != "" || *maxSize != "" || *sample != 0 {
+			quitWith("-noredirects, -minsize, -maxsize, and -sample only used when packing")
+		}
+		if *maxSegment != "" || *maxBuffer != "" {
+			quitWith("-maxsegment and -maxbuffer only used when packing")
+		}
+		if *element != "page" || *keyTag != "id" {
+			quitWith("-element and -key only used when packing (unpacking doesn't need them)")
+		}
 	} else { // validate as if packing
 		if *compression == "auto" {
-			if zip.CanWrite("bz2") {
-				*compression = "bz2"
-			} else {
-				*compression = "gz"
-			}
+			*compression = "bz2"
 		}
 		if *changeDump {
 			quitWith("-changedump only available when unpacking")
 		}
-		if *compression == "none" {
-			*compression = ""
-		}
-		*compression = zip.CanonicalFormatName(*compression)
-		if *compression != "" && !zip.IsKnown(*compression) {
-			quitWith("unknown compression type '" + *compression + "'")
-		}
-		if *compression != "" && !zip.CanWrite(*compression) {
-			quitWith("can't find (un)packer for ." + *compression)
+		if *rewind != "" {
+			quitWith("-rewind only used when unpacking")
 		}
+		parseZipFlag()
 		if *useFile {
 			quitWith("-f is redundant when packing")
 		}
+		if *format != "xml" {
+			quitWith("-format only used with -cut, -merge, and unpacking")
+		}
 		if *useStdout {
 			quitWith("-c not allowed when packing (won't pack to stdout)")
 		}
-
-		if *nsString != "" {
-			limitToNS = true
-			var err error
-			ns, err = strconv.Atoi(*nsString)
-			if err != nil {
-				quitWith("ns must be an integer")
-			}
-		}
+		parseCutOptions()
 	}
 
 	// with help from http://blog.golang.org/profiling-go-programs
@@ -280,8 +481,21 @@ func main() {
 	filenames := args[:]
 	if *cut {
 		CutStdinToStdout()
+	} else if *info {
+		name := ""
+		if len(filenames) > 0 {
+			name = filenames[0]
+		}
+		PrintInfo(name)
+	} else if *sortDump {
+		SortStdinToStdout()
+	} else if *splitCount != 0 || *splitSize != "" {
+		name := ""
+		if len(filenames) > 0 {
+			name = filenames[0]
+		}
+		Split(name)
 	} else if *merge {
-		var sources = make([]io.Reader, len(filenames))
 		dir := filepath.Dir(filenames[0])
 		if strings.HasPrefix(filenames[0], "http://") {
 			dir = "."
@@ -290,16 +504,28 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		for i, fn := range filenames {
-			f, err := zip.Open(fn, workingDir)
-			if err != nil {
-				quitWith("can't open source " + fn + ": " + err.Error())
+		if *deletionsList != "" {
+			deletions = chunk.NewDeletionList()
+			for _, fn := range strings.Split(*deletionsList, ",") {
+				f, err := zip.Open(fn, workingDir)
+				if err != nil {
+					quitWith("can't open deletion list " + fn + ": " + err.Error())
+				}
+				err = deletions.Add(f)
+				if err != nil {
+					quitWith("can't read deletion list " + fn + ": " + err.Error())
+				}
+				f.Close()
 			}
-			sources[i] = f
 		}
-		Merge(sources, os.Stdout)
+		out := convertOutput(os.Stdout)
+		Merge(filenames, workingDir, out)
+		err = out.Close()
+		if err != nil {
+			panic(err)
+		}
 	} else if len(filenames) < 2 { //expand
-		var dp stream.Stream
+		var dp io.Reader
 		var err error
 
 		// decide on working dir
@@ -325,7 +551,7 @@ func main() {
 				quitWith("can't open source " + filenames[0] + ": " + err.Error())
 			}
 		} else {
-			dp = os.Stdin
+			dp = stdinReader()
 			streaming = true
 		}
 		ReadDiffPack(dp, workingDir, streaming)
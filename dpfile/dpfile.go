@@ -20,6 +20,8 @@ import (
 	"path/filepath"
 	"regexp" // validating input filenames
 	"runtime"
+	"sort"
+	"strings"
 )
 
 /*
@@ -57,7 +59,7 @@ diffs.
 You can see dltp.go for invocation with all the bells and whistles, but use of these
 classes goes roughly like:
 
-dpw := dpfile.NewWriter(out, workingDir, sources, [options])
+dpw := dpfile.NewWriter(out, workingDir, sources, mwxmlchunk.Options{...})
 for dpw.WriteSegment() {} // turn XML into diffs until you run out
 dpw.Close() // write end marker and any etc., flush output
 
@@ -129,20 +131,29 @@ type DPBlock struct {
 type DPBlocks []DPBlock
 
 type DPWriter struct {
-	out     *bufio.Writer
-	zOut    io.WriteCloser
-	sources []*mwxmlchunk.SegmentReader
-	lastSeg []byte
-	tasks   []DiffTask
-	blocks  DPBlocks
-	taskCh  chan *DiffTask
-	slots   int
-	winner  int
+	out         *bufio.Writer
+	zOut        io.WriteCloser
+	sources     []*mwxmlchunk.SegmentReader
+	sourceFiles []stream.Stream
+	sourceNames []string
+	indexes     []sourceIndex // for references we can't just read in order
+	indexBuf    []byte
+	refOpts     mwxmlchunk.Options // how to read references
+	lastSeg     []byte
+	tasks       []DiffTask
+	blocks      DPBlocks
+	taskCh      chan *DiffTask
+	slots       int
+	winner      int
 }
 
 type DPReader struct {
 	in         *bufio.Reader
 	out        *bufio.Writer
+	outPath    string    // "" for stdout
+	w          io.Writer // out, or conv if we're converting
+	conv       io.WriteCloser
+	convert    func(io.Writer) io.WriteCloser // see ConvertOutput
 	sources    []io.ReaderAt
 	lastSeg    []byte
 	ChangeDump bool
@@ -150,22 +161,30 @@ type DPReader struct {
 
 var MaxSourceLength = uint64(1e8)
 
-func NewWriter(zOut io.WriteCloser, workingDir *os.File, sourceNames []string, lastRevOnly bool, limitToNS bool, ns int, cutMeta bool) (dpw DPWriter) {
+func NewWriter(zOut io.WriteCloser, workingDir *os.File, sourceNames []string, opts mwxmlchunk.Options) (dpw DPWriter) {
 	for i, name := range sourceNames {
-		r, err := zip.Open(name, workingDir)
+		open := zip.Open
+		if i > 0 { // references may need random access
+			open = zip.OpenRandomAccess
+		}
+		r, err := open(name, workingDir)
 		if err != nil {
 			panic("cannot open source: " + err.Error())
 		}
 		f := stream.NewReaderAt(r)
 		dpw.sources = append(
 			dpw.sources,
-			mwxmlchunk.NewSegmentReader(f, int64(i), lastRevOnly, limitToNS, ns, cutMeta),
+			mwxmlchunk.NewSegmentReader(f, int64(i), opts),
 		)
-		// only use snipping options when reading first source
-		lastRevOnly = false
-		limitToNS = false
-		cutMeta = false
-	}
+		dpw.sourceFiles = append(dpw.sourceFiles, r)
+		dpw.sourceNames = append(dpw.sourceNames, name)
+		dpw.indexes = append(dpw.indexes, nil)
+		// only use snipping options when reading first source; references
+		// still get read as the same elements and split into parts the same
+		// way, so the keys line up
+		opts = opts.Uncut()
+	}
+	dpw.refOpts = opts
 	dpw.zOut = zOut
 	dpw.out = bufio.NewWriter(zOut)
 	_, err := dpw.out.WriteString("DeltaPacker\nno format URL yet\nno source URL\n\n")
@@ -227,14 +246,28 @@ func (dpw *DPWriter) WriteSegment() bool {
 	source := sref.SourceNotFound
 	aText := []byte(nil)
 	bText, key, _, revFetchErr := b.ReadNext()
+	if _, ok := revFetchErr.(*mwxmlchunk.UnsortedError); ok {
+		// input's out of order, so we can't just read forward in references
+		for i := range a {
+			dpw.indexSource(i + 1)
+		}
+		revFetchErr = nil
+	}
 	if revFetchErr != nil && revFetchErr != io.EOF {
 		panic(revFetchErr)
 	}
-	for _, src := range a {
-		err := error(nil)
-		aText, _, source, err = src.ReadTo(key)
-		if err != nil && err != io.EOF {
-			panic(err)
+	for i, src := range a {
+		if dpw.indexes[i+1] != nil {
+			aText, source = dpw.lookup(i+1, key)
+		} else {
+			err := error(nil)
+			aText, _, source, err = src.ReadTo(key)
+			if _, ok := err.(*mwxmlchunk.UnsortedError); ok {
+				dpw.indexSource(i + 1)
+				aText, source = dpw.lookup(i+1, key)
+			} else if err != nil && err != io.EOF {
+				panic(err)
+			}
 		}
 		if len(aText) > 0 {
 			break
@@ -267,6 +300,74 @@ func (dpw *DPWriter) WriteSegment() bool {
 	return true
 }
 
+/*
+
+Input and references are supposed to be sorted by page ID, so WriteSegment can
+find reference pages just by reading forward. When it turns out something
+isn't, we scan the reference once to make a sourceIndex of where each page is,
+then look pages up in that and ReadAt them.
+
+This needs random access to the reference, which we don't have if it's
+compressed, unless zip.SpillDir or CacheDir has us decompressing it to disk.
+
+*/
+
+type indexEntry struct {
+	Key    mwxmlchunk.SegmentKey
+	Source sref.SourceRef
+}
+
+type sourceIndex []indexEntry
+
+func (idx sourceIndex) Len() int           { return len(idx) }
+func (idx sourceIndex) Less(i, j int) bool { return idx[i].Key < idx[j].Key }
+func (idx sourceIndex) Swap(i, j int)      { idx[i], idx[j] = idx[j], idx[i] }
+
+func (dpw *DPWriter) indexSource(i int) {
+	if dpw.indexes[i] != nil {
+		return
+	}
+	f := dpw.sourceFiles[i]
+	if _, ok := f.(*stream.StreamReaderAt); ok {
+		panic("reference " + dpw.sourceNames[i] + " is compressed, and either it or the input isn't sorted by page ID. decompress it (or use -spill), or sort both with dltp -sort, and try again.")
+	}
+	idx := sourceIndex{}
+	sr := mwxmlchunk.NewSegmentReader(
+		io.NewSectionReader(f, 0, 1<<62),
+		int64(i),
+		dpw.refOpts,
+	)
+	for {
+		_, key, source, err := sr.ReadNext()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*mwxmlchunk.UnsortedError); !ok && err != nil {
+			panic(err)
+		}
+		if key != mwxmlchunk.StartKey {
+			idx = append(idx, indexEntry{key, source})
+		}
+	}
+	sort.Stable(idx)
+	dpw.indexes[i] = idx
+}
+
+func (dpw *DPWriter) lookup(i int, key mwxmlchunk.SegmentKey) ([]byte, sref.SourceRef) {
+	idx := dpw.indexes[i]
+	j := sort.Search(len(idx), func(j int) bool { return idx[j].Key >= key })
+	if j == len(idx) || idx[j].Key != key || idx[j].Source.Length > MaxSourceLength {
+		return nil, sref.SourceNotFound
+	}
+	source := idx[j].Source
+	dpw.indexBuf = alloc.Bytes(dpw.indexBuf, int(source.Length))
+	_, err := dpw.sourceFiles[i].ReadAt(dpw.indexBuf, int64(source.Start))
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+	return dpw.indexBuf, source
+}
+
 func (dpw *DPWriter) Close() {
 	for i := range dpw.tasks { // heh, we have to use i
 		t := &dpw.tasks[(dpw.winner+i)%dpw.slots]
@@ -349,26 +450,17 @@ func NewReader(in io.Reader, workingDir *os.File, streaming bool) (dpr DPReader)
 	// open the first source, a.k.a. the output, for writing:
 	dirName := workingDir.Name()
 	outputName := panicOnUnsafeName(readLineOrPanic(dpr.in))
-	outputPath := path.Join(dirName, outputName)
-	var outFile *os.File
-	var err error
-	if streaming {
-		outFile = os.Stdout
-	} else {
-		outFile, err = os.Create(outputPath)
-		if err != nil {
-			panic("cannot create output")
-		}
+	if !streaming {
+		dpr.outPath = path.Join(dirName, outputName)
 	}
-	dpr.out = bufio.NewWriter(outFile)
 	// open all sources for reading, including the output
 	for sourceName := outputName; sourceName != ""; sourceName = panicOnUnsafeName(readLineOrPanic(dpr.in)) {
-		if streaming && sourceName == outputName {
+		if len(dpr.sources) == 0 { // the output
 			dpr.sources = append(dpr.sources, nil) // don't read from me!
 			continue
 		}
 		sourcePath := path.Join(dirName, sourceName)
-		zipReader, err := zip.Open(sourcePath, workingDir)
+		zipReader, err := zip.OpenRandomAccess(sourcePath, workingDir)
 		if err != nil {
 			panic("could not open source " + sourceName + ": " + err.Error())
 		}
@@ -384,11 +476,32 @@ func NewReader(in io.Reader, workingDir *os.File, streaming bool) (dpr DPReader)
 
 var readBuf []byte // not parallel-safe, but reading isn't threaded
 
+// output isn't created 'til we need it, so ConvertOutput can rename it
+func (dpr *DPReader) openOutput() {
+	outFile := os.Stdout
+	if dpr.outPath != "" {
+		var err error
+		outFile, err = os.Create(dpr.outPath)
+		if err != nil {
+			panic("cannot create output")
+		}
+	}
+	dpr.out = bufio.NewWriter(outFile)
+	dpr.w = dpr.out
+	if dpr.convert != nil {
+		dpr.conv = dpr.convert(dpr.out)
+		dpr.w = dpr.conv
+	}
+}
+
 func (dpr *DPReader) ReadSegment() bool { // writes to self.out
+	if dpr.out == nil {
+		dpr.openOutput()
+	}
 	source := sref.ReadSource(dpr.in)
 	if source == sref.EOFMarker {
 		if dpr.ChangeDump {
-			_, err := dpr.out.Write(dpr.lastSeg)
+			_, err := dpr.w.Write(dpr.lastSeg)
 			if err != nil {
 				panic("couldn't write expanded file")
 			}
@@ -410,6 +523,9 @@ func (dpr *DPReader) ReadSegment() bool { // writes to self.out
 			panic("too-high source number provided")
 		}
 		srcFile := dpr.sources[source.SourceNumber]
+		if srcFile == nil {
+			panic("source refers to the file being unpacked")
+		}
 		_, err := srcFile.ReadAt(orig, int64(source.Start))
 		if err != nil {
 			//fmt.Println("error reading from source", source)
@@ -467,7 +583,7 @@ func (dpr *DPReader) ReadSegment() bool { // writes to self.out
 
 	// write if not ChangeDump or if changed or if this is preamble
 	if !dpr.ChangeDump || !bytes.Equal(text, orig) || dpr.lastSeg == nil {
-		_, err := dpr.out.Write(text)
+		_, err := dpr.w.Write(text)
 		if err != nil {
 			panic("couldn't write expanded file")
 		}
@@ -478,11 +594,31 @@ func (dpr *DPReader) ReadSegment() bool { // writes to self.out
 	return true
 }
 
+// ConvertOutput runs the expanded XML through a converter (like
+// jsonl.NewWriter) before it's written. If we're writing to a file rather than
+// stdout, the file's .xml suffix becomes newSuffix. Call it before
+// ReadSegment.
+func (dpr *DPReader) ConvertOutput(convert func(io.Writer) io.WriteCloser, newSuffix string) {
+	if dpr.outPath != "" {
+		dpr.outPath = strings.TrimSuffix(dpr.outPath, ".xml") + newSuffix
+	}
+	dpr.convert = convert
+}
+
 func (dpr *DPReader) Close() {
 	for _, r := range dpr.sources {
 		if c, ok := r.(io.Closer); ok {
 			c.Close()
 		}
 	}
+	if dpr.out == nil {
+		return
+	}
+	if dpr.conv != nil {
+		err := dpr.conv.Close()
+		if err != nil {
+			panic("couldn't convert expanded file: " + err.Error())
+		}
+	}
 	dpr.out.Flush()
 }
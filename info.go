@@ -0,0 +1,80 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/twotwotwo/dltp/zip"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+/*
+
+INFO
+
+-info prints what a dump's <siteinfo> says about the wiki it's from,
+including the namespace table, so you can see what -ns names mean.
+
+*/
+
+var info = flag.Bool("info", false, "print the wiki info and namespaces from a dump's <siteinfo>")
+
+// PrintInfo prints the siteinfo of the named dump, or stdin if name is "".
+func PrintInfo(name string) {
+	var in io.Reader
+	if name == "" {
+		in = stdinReader()
+	} else {
+		// a URL gets downloaded to the current directory, as when unpacking
+		dir := filepath.Dir(name)
+		if strings.HasPrefix(name, "http://") {
+			dir = "."
+		}
+		workingDir, err := os.Open(dir)
+		if err != nil {
+			quitWith("can't open %s: %s", dir, err)
+		}
+		defer workingDir.Close()
+		f, err := zip.Open(name, workingDir)
+		if err != nil {
+			quitWith("can't open %s: %s", name, err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r := chunk.NewSegmentReader(in, 0, chunk.Options{})
+	text, _, _, err := r.ReadNext()
+	if err != nil && err != io.EOF {
+		if _, ok := err.(*chunk.UnsortedError); !ok {
+			panic(err)
+		}
+	}
+	si, err := chunk.ParseSiteInfo(text)
+	if err != nil {
+		quitWith("%s", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(w, "sitename: ", si.SiteName)
+	fmt.Fprintln(w, "dbname:   ", si.DBName)
+	fmt.Fprintln(w, "base:     ", si.Base)
+	fmt.Fprintln(w, "generator:", si.Generator)
+	fmt.Fprintln(w, "case:     ", si.Case)
+	fmt.Fprintln(w, "namespaces:")
+	for _, ns := range si.Namespaces {
+		name := ns.Name
+		if ns.Key == 0 && name == "" {
+			name = "(main)"
+		}
+		fmt.Fprintf(w, "  %5d  %s\n", ns.Key, name)
+	}
+	w.Flush()
+}
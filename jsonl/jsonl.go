@@ -0,0 +1,225 @@
+// Public domain, dltp contributors, 2026
+
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"html" // decoding entities
+	"io"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+/*
+
+JSON LINES OUTPUT
+
+NewWriter(out, maxSegmentSize) gives you a WriteCloser you can write MediaWiki
+XML to (in whatever size pieces); it writes one JSON object per page to out:
+
+  {"id":12,"ns":0,"title":"Anarchism","redirect":"...","revisions":[
+    {"id":34,"timestamp":"...","contributor":{"username":"...","id":56},
+     "comment":"...","text":"..."}, ...]}
+
+Underneath, it's just a SegmentReader reading from a pipe, so it works the same
+on -cut, -merge, or unpacked output. Revisions are written as they come, so a
+page split into parts (see mwxmlchunk.Options.MaxSegmentSize) never has to be
+in memory at once.
+
+We don't use encoding/xml, to stay fast: a dump's text is XML-escaped, so every
+'<' is markup, and we can find fields by looking for their tags.
+
+*/
+
+type Contributor struct {
+	Username string `json:"username,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	IP       string `json:"ip,omitempty"`
+}
+
+type Revision struct {
+	ID          int64        `json:"id"`
+	Timestamp   string       `json:"timestamp"`
+	Contributor *Contributor `json:"contributor,omitempty"`
+	Comment     string       `json:"comment,omitempty"`
+	Text        string       `json:"text"`
+}
+
+type pageHeader struct {
+	ID       int64  `json:"id"`
+	NS       int    `json:"ns"`
+	Title    string `json:"title"`
+	Redirect string `json:"redirect,omitempty"`
+}
+
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+var revisionTag = []byte("<revision>")
+var closeRevisionTag = []byte("</revision>")
+var closePageTag = []byte("</page>")
+var contributorTag = []byte("<contributor")
+var redirectTag = []byte("<redirect")
+
+func NewWriter(out io.Writer, maxSegmentSize int) io.WriteCloser {
+	r, w := io.Pipe()
+	jw := &Writer{pw: w, done: make(chan error, 1)}
+	go func() {
+		err := convert(r, out, maxSegmentSize)
+		// if we bailed, don't leave the writer hanging
+		r.CloseWithError(err)
+		jw.done <- err
+	}()
+	return jw
+}
+
+func (jw *Writer) Write(p []byte) (n int, err error) {
+	return jw.pw.Write(p)
+}
+
+// Close waits for the last page to be written out.
+func (jw *Writer) Close() error {
+	jw.pw.Close()
+	return <-jw.done
+}
+
+// json.Encoder, but without the newline after each value (and without
+// escaping <>&, which wikitext is full of)
+type encoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+	bw  *bufio.Writer
+}
+
+func newEncoder(bw *bufio.Writer) (e *encoder) {
+	e = &encoder{bw: bw}
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetEscapeHTML(false)
+	return
+}
+
+func (e *encoder) encode(v interface{}) (err error) {
+	e.buf.Reset()
+	err = e.enc.Encode(v)
+	if err != nil {
+		return
+	}
+	_, err = e.bw.Write(bytes.TrimSuffix(e.buf.Bytes(), []byte("\n")))
+	return
+}
+
+func convert(in io.Reader, out io.Writer, maxSegmentSize int) (err error) {
+	bw := bufio.NewWriter(out)
+	enc := newEncoder(bw)
+	sr := chunk.NewSegmentReader(in, 0, chunk.Options{MaxSegmentSize: maxSegmentSize})
+	revsWritten := 0
+	inPage := false
+	for {
+		text, key, _, readErr := sr.ReadNext()
+		// we convert a page at a time, so order doesn't matter here; whatever
+		// read the input in (-cut, -merge, unpacking) already checked it
+		if _, ok := readErr.(*chunk.UnsortedError); ok {
+			readErr = nil
+		}
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if key != chunk.StartKey && key != chunk.PastEndKey {
+			if key.Part() == 0 {
+				revsWritten = 0
+				inPage = true
+				err = writeHeader(enc, text)
+				if err != nil {
+					return err
+				}
+			}
+			for {
+				start := bytes.Index(text, revisionTag)
+				if start == -1 {
+					break
+				}
+				end := bytes.Index(text[start:], closeRevisionTag)
+				if end == -1 {
+					break
+				}
+				end += start
+				if revsWritten > 0 {
+					bw.WriteByte(',')
+				}
+				err = enc.encode(parseRevision(text[start:end]))
+				if err != nil {
+					return err
+				}
+				revsWritten++
+				text = text[end:]
+			}
+			if bytes.Contains(text, closePageTag) {
+				bw.WriteString("]}\n")
+				inPage = false
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if inPage { // truncated input; at least leave valid JSON
+		bw.WriteString("]}\n")
+	}
+	return bw.Flush()
+}
+
+func writeHeader(enc *encoder, text []byte) error {
+	header := text
+	if i := bytes.Index(text, revisionTag); i > -1 {
+		header = text[:i]
+	}
+	h := pageHeader{
+		ID:    chunk.ElementInt(header, "id"),
+		NS:    int(chunk.ElementInt(header, "ns")),
+		Title: field(header, "title"),
+	}
+	if i := bytes.Index(header, redirectTag); i > -1 {
+		h.Redirect = html.UnescapeString(string(chunk.Attr(header[i:], "title")))
+	}
+	// encode the header, then reopen it to hold the revisions
+	enc.buf.Reset()
+	err := enc.enc.Encode(h)
+	if err != nil {
+		return err
+	}
+	enc.bw.Write(bytes.TrimRight(enc.buf.Bytes(), "}\n"))
+	_, err = enc.bw.WriteString(`,"revisions":[`)
+	return err
+}
+
+func parseRevision(rev []byte) (r Revision) {
+	// pull out the contributor so its <id> doesn't get mistaken for ours
+	if i := bytes.Index(rev, contributorTag); i > -1 {
+		contrib, end := chunk.Element(rev[i:], "contributor")
+		if end > -1 {
+			if contrib != nil {
+				r.Contributor = &Contributor{
+					Username: field(contrib, "username"),
+					ID:       chunk.ElementInt(contrib, "id"),
+					IP:       field(contrib, "ip"),
+				}
+			}
+			rev = append(rev[:i:i], rev[i+end:]...)
+		}
+	}
+	r.ID = chunk.ElementInt(rev, "id")
+	r.Timestamp = field(rev, "timestamp")
+	r.Comment = field(rev, "comment")
+	r.Text = field(rev, "text")
+	return
+}
+
+// field gets an element's content with entities decoded
+func field(in []byte, name string) string {
+	content, _ := chunk.Element(in, name)
+	return html.UnescapeString(string(content))
+}
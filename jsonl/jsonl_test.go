@@ -0,0 +1,75 @@
+// Public domain, dltp contributors, 2026
+
+package jsonl
+
+import (
+	"bytes"
+	"testing"
+)
+
+const dump = `<mediawiki>
+  <siteinfo>
+    <sitename>Test</sitename>
+  </siteinfo>
+  <page>
+    <title>A &amp; B</title>
+    <ns>0</ns>
+    <id>1</id>
+    <revision>
+      <id>10</id>
+      <timestamp>2013-01-01T00:00:00Z</timestamp>
+      <contributor>
+        <username>Someone</username>
+        <id>7</id>
+      </contributor>
+      <comment>"quoted"</comment>
+      <text xml:space="preserve">&lt;b&gt;bold&lt;/b&gt; &amp; a
+newline	and tab</text>
+    </revision>
+    <revision>
+      <id>11</id>
+      <timestamp>2013-01-02T00:00:00Z</timestamp>
+      <contributor>
+        <ip>10.0.0.1</ip>
+      </contributor>
+      <text xml:space="preserve">second</text>
+    </revision>
+  </page>
+  <page>
+    <title>C</title>
+    <ns>2</ns>
+    <id>3</id>
+    <redirect title="A &amp; B" />
+    <revision>
+      <id>12</id>
+      <timestamp>2013-01-03T00:00:00Z</timestamp>
+      <contributor deleted="deleted" />
+      <text xml:space="preserve">#REDIRECT [[A &amp; B]]</text>
+    </revision>
+  </page>
+</mediawiki>
+`
+
+const want = `{"id":1,"ns":0,"title":"A & B","revisions":[` +
+	`{"id":10,"timestamp":"2013-01-01T00:00:00Z","contributor":{"username":"Someone","id":7},"comment":"\"quoted\"","text":"<b>bold</b> & a\nnewline\tand tab"},` +
+	`{"id":11,"timestamp":"2013-01-02T00:00:00Z","contributor":{"ip":"10.0.0.1"},"text":"second"}]}
+{"id":3,"ns":2,"title":"C","redirect":"A & B","revisions":[` +
+	`{"id":12,"timestamp":"2013-01-03T00:00:00Z","text":"#REDIRECT [[A & B]]"}]}
+`
+
+func TestWriter(t *testing.T) {
+	// whole pages, and pages split into one part per revision or so
+	for _, maxSegmentSize := range []int{0, 200} {
+		out := &bytes.Buffer{}
+		w := NewWriter(out, maxSegmentSize)
+		// writes that don't line up with anything
+		w.Write([]byte(dump[:100]))
+		w.Write([]byte(dump[100:]))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("maxSegmentSize %d: got\n%s\nwant\n%s", maxSegmentSize, out, want)
+		}
+	}
+}
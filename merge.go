@@ -0,0 +1,327 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/twotwotwo/dltp/zip"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+/*
+
+MERGING
+
+A k-way merge: each input's next segment sits in a heap ordered by key, then by
+precedence (the input's position on the command line), so the top of the heap
+is always the next page to write and the version of it that wins. After
+writing it we advance every input that had that key.
+
+Once a page is split into parts (-maxsegment), all of its parts come from the
+input that won part 0, since parts from different files don't fit together.
+
+With more inputs than -maxopen, we don't open them all at once: we merge
+consecutive groups of them into temp files, in order, then merge those. That
+keeps precedence intact, since each group takes the precedence of its inputs.
+
+With -newest, precedence only breaks ties: when several inputs have a page, we
+keep the one whose newest revision is latest (by timestamp, then revision ID),
+and report on stderr when that's not the version file order would have picked.
+To compare pages split into parts, we have to read every candidate to its end,
+so all but the first part of each go to a temp file until we've decided.
+
+-deletions needs the same when a deletion is logged under a page's title: the
+page only goes if its newest revision, which is in its last part, is older than
+the deletion.
+
+*/
+
+var maxOpen = flag.Int("maxopen", 200, "when merging, max input files to have open at once")
+var newest = flag.Bool("newest", false, "when merging, keep the version of a page with the newest revision, not the leftmost file's")
+
+type mergeSource struct {
+	name       string
+	r          *chunk.SegmentReader
+	precedence int
+	key        chunk.SegmentKey
+	text       []byte
+	done       bool // returned the last segment already
+}
+
+// read the next segment; false if there isn't one
+func (src *mergeSource) advance() bool {
+	if src.done {
+		return false
+	}
+	text, key, _, err := src.r.ReadNext()
+	if _, ok := err.(*chunk.UnsortedError); ok {
+		panic(fmt.Sprint(src.name, " isn't sorted, which -merge needs (try dltp -sort): ", err))
+	}
+	if err == io.EOF {
+		src.done = true
+	} else if err != nil {
+		panic(err)
+	}
+	src.text, src.key = text, key
+	return true
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].precedence < h[j].precedence
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// Merge merges the named files (first takes precedence) to out, applying the
+// cutting options and -deletions.
+func Merge(names []string, workingDir *os.File, out io.Writer) {
+	mergeFiles(names, names, workingDir, cutOpts, out, true)
+}
+
+// labels name the inputs in messages; final is whether this is the last merge
+// step, rather than one writing to a temp file
+func mergeFiles(names []string, labels []string, workingDir *os.File, opts chunk.Options, out io.Writer, final bool) {
+	if len(names) > *maxOpen {
+		tempNames, tempLabels := []string(nil), []string(nil)
+		defer func() {
+			for _, fn := range tempNames {
+				os.Remove(fn)
+			}
+		}()
+		for start := 0; start < len(names); start += *maxOpen {
+			end := start + *maxOpen
+			if end > len(names) {
+				end = len(names)
+			}
+			f, err := os.CreateTemp("", "dltp-merge-*.xml")
+			if err != nil {
+				panic(err)
+			}
+			tempNames = append(tempNames, f.Name())
+			label := labels[start]
+			if end-start > 1 {
+				label += " through " + labels[end-1]
+			}
+			tempLabels = append(tempLabels, label)
+			mergeFiles(names[start:end], labels[start:end], workingDir, opts, f, false)
+			err = f.Close()
+			if err != nil {
+				panic(err)
+			}
+		}
+		// the temp files are already cut down
+		mergeFiles(tempNames, tempLabels, workingDir, opts.Uncut(), out, final)
+		return
+	}
+
+	sources := make([]*mergeSource, len(names))
+	for i, fn := range names {
+		f, err := zip.Open(fn, workingDir)
+		if err != nil {
+			quitWith("can't open source " + labels[i] + ": " + err.Error())
+		}
+		sources[i] = &mergeSource{
+			name:       labels[i],
+			r:          chunk.NewSegmentReader(f, int64(i), opts),
+			precedence: i,
+		}
+	}
+	mergeSources(sources, out, final)
+}
+
+// buffers merged output, and fixes up the whitespace between pages
+type mergeWriter struct {
+	*bufio.Writer
+	indent    []byte // what goes before a <page>
+	wrotePage bool
+}
+
+func (w *mergeWriter) writeSegment(key chunk.SegmentKey, text []byte) {
+	if key == chunk.StartKey {
+		w.indent = []byte("\n")
+		if i := bytes.LastIndexByte(text, '\n'); i > -1 {
+			w.indent = append(w.indent, text[i+1:]...)
+		}
+	} else if key != chunk.PastEndKey && key.Part() == 0 {
+		// an input's first page doesn't start with the line break and
+		// indentation (they end its preamble), so supply them; and when
+		// the first page we write wasn't first in its input, don't double them
+		if !w.wrotePage {
+			text = bytes.TrimLeft(text, " \t\r\n")
+		} else if len(text) > 0 && text[0] == '<' {
+			w.Write(w.indent)
+		}
+		w.wrotePage = true
+	}
+	_, err := w.Write(text)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mergeSources(sources []*mergeSource, out io.Writer, final bool) {
+	w := &mergeWriter{Writer: bufio.NewWriterSize(out, 1<<20)}
+	h := &mergeHeap{}
+	for _, src := range sources {
+		if src.advance() {
+			heap.Push(h, src)
+		}
+	}
+
+	winner := -1 // precedence of the input whose version of the page we're writing
+	for h.Len() > 0 {
+		top := (*h)[0]
+		key := top.key
+		isPage := key != chunk.StartKey && key != chunk.PastEndKey
+		if isPage && key.Part() == 0 &&
+			(*newest && h.Len() > 1 && sameKey(h, key) ||
+				final && deletions != nil && deletions.ByTitle(top.text)) {
+			mergeWhole(h, w, final)
+			continue
+		}
+		if key.Part() == 0 || !isPage {
+			winner = top.precedence
+			if final && deletions != nil && isPage && deletions.Deleted(key, top.text, nil) {
+				winner = -1 // skip it and any other parts
+			}
+		}
+		if top.precedence == winner {
+			w.writeSegment(key, top.text)
+		}
+
+		// move everything past this key
+		for h.Len() > 0 && (*h)[0].key == key {
+			src := heap.Pop(h).(*mergeSource)
+			if src.advance() {
+				heap.Push(h, src)
+			} else {
+				src.r.Close()
+			}
+		}
+	}
+
+	err := w.Flush()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// sameKey says whether more than one input is at key (the top of the heap),
+// checking the top's children
+func sameKey(h *mergeHeap, key chunk.SegmentKey) bool {
+	return (*h)[1].key == key || h.Len() > 2 && (*h)[2].key == key
+}
+
+// a version of a page, for mergeWhole
+type candidate struct {
+	src       *mergeSource
+	first     []byte
+	rest      *os.File // parts after the first, if any
+	timestamp []byte
+	revID     int64
+}
+
+func (c *candidate) newerThan(o *candidate) bool {
+	if cmp := bytes.Compare(c.timestamp, o.timestamp); cmp != 0 {
+		return cmp > 0
+	}
+	return c.revID > o.revID
+}
+
+func (c *candidate) note(text []byte) {
+	ts, id := chunk.NewestRevision(text)
+	if cmp := bytes.Compare(ts, c.timestamp); cmp > 0 || cmp == 0 && id > c.revID {
+		c.timestamp = append(c.timestamp[:0], ts...)
+		c.revID = id
+	}
+}
+
+// mergeWhole reads every version of the page at the top of the heap to its
+// end, writes the winner (the newest, with -newest) unless -deletions says to
+// drop it, and advances all of their inputs past it.
+func mergeWhole(h *mergeHeap, w *mergeWriter, final bool) {
+	key := (*h)[0].key
+	candidates := []*candidate(nil)
+	for h.Len() > 0 && (*h)[0].key == key {
+		src := heap.Pop(h).(*mergeSource)
+		c := &candidate{src: src, first: append([]byte(nil), src.text...)}
+		c.note(c.first)
+		candidates = append(candidates, c)
+		// read the rest of the page
+		for {
+			if !src.advance() {
+				src.r.Close()
+				break
+			}
+			if src.key.ID() != key.ID() || src.key == chunk.PastEndKey {
+				heap.Push(h, src)
+				break
+			}
+			if c.rest == nil {
+				f, err := os.CreateTemp("", "dltp-page-*.xml")
+				if err != nil {
+					panic(err)
+				}
+				os.Remove(f.Name()) // we only need it while it's open
+				c.rest = f
+			}
+			_, err := c.rest.Write(src.text)
+			if err != nil {
+				panic(err)
+			}
+			c.note(src.text)
+		}
+	}
+
+	// candidates are in precedence order, so ties go to the leftmost file
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if *newest && c.newerThan(best) {
+			best = c
+		}
+	}
+	if best != candidates[0] {
+		c := candidates[0]
+		fmt.Fprintf(os.Stderr, "page %d: taking revision %d (%s) from %s over revision %d (%s) from %s\n",
+			key.ID(), best.revID, best.timestamp, best.src.name, c.revID, c.timestamp, c.src.name)
+	}
+
+	if !(final && deletions != nil && deletions.Deleted(key, best.first, best.timestamp)) {
+		w.writeSegment(key, best.first)
+		if best.rest != nil {
+			_, err := best.rest.Seek(0, 0)
+			if err != nil {
+				panic(err)
+			}
+			_, err = io.Copy(w, best.rest)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	for _, c := range candidates {
+		if c.rest != nil {
+			c.rest.Close()
+		}
+	}
+}
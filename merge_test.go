@@ -0,0 +1,140 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+// testPage is a page with one revision per timestamp, each with the text
+// "<id>@<timestamp> <label>"
+func testPage(id string, label string, timestamps ...string) string {
+	page := "  <page>\n    <title>T" + id + "</title>\n    <id>" + id + "</id>\n"
+	for _, ts := range timestamps {
+		page += "    <revision>\n      <timestamp>" + ts + "</timestamp>\n" +
+			"      <text>" + id + "@" + ts + " " + label + "</text>\n    </revision>\n"
+	}
+	return page + "  </page>\n"
+}
+
+func testDump(pages ...string) string {
+	return "<mediawiki>\n" + strings.Join(pages, "") + "</mediawiki>\n"
+}
+
+// writeDumps writes each dump to a file in a temp dir and returns their names
+func writeDumps(t *testing.T, dumps ...string) (names []string) {
+	dir := t.TempDir()
+	for i, dump := range dumps {
+		fn := filepath.Join(dir, string(rune('a'+i))+".xml")
+		if err := os.WriteFile(fn, []byte(dump), 0666); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, fn)
+	}
+	return
+}
+
+func testMerge(t *testing.T, names []string, opts chunk.Options) string {
+	out := &bytes.Buffer{}
+	mergeFiles(names, names, nil, opts, out, true)
+	return out.String()
+}
+
+func setFlag(t *testing.T, p *int, v int) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func TestMerge(t *testing.T) {
+	names := writeDumps(t,
+		testDump(testPage("1", "a", "2001"), testPage("4", "a", "2001")),
+		testDump(testPage("1", "b", "2009"), testPage("2", "b", "2001"), testPage("4", "b", "2001")),
+		testDump(testPage("2", "c", "2001"), testPage("3", "c", "2001"), testPage("5", "c", "2001")),
+	)
+	want := testDump(
+		testPage("1", "a", "2001"),
+		testPage("2", "b", "2001"),
+		testPage("3", "c", "2001"),
+		testPage("4", "a", "2001"),
+		testPage("5", "c", "2001"),
+	)
+	if got := testMerge(t, names, chunk.Options{}); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// merging in groups through temp files keeps precedence
+	setFlag(t, maxOpen, 2)
+	if got := testMerge(t, names, chunk.Options{}); got != want {
+		t.Fatalf("with -maxopen 2, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeParts(t *testing.T) {
+	// page 1 is split into parts in both inputs, differently; all of the
+	// output's parts have to come from the first
+	names := writeDumps(t,
+		testDump(testPage("1", "a", "2001", "2002", "2003", "2004"), testPage("2", "a", "2001")),
+		testDump(testPage("1", "bbbbbbbbbb", "2001", "2002", "2003", "2004", "2005"), testPage("3", "b", "2001")),
+	)
+	want := testDump(
+		testPage("1", "a", "2001", "2002", "2003", "2004"),
+		testPage("2", "a", "2001"),
+		testPage("3", "b", "2001"),
+	)
+	opts := chunk.Options{MaxSegmentSize: 150}
+	if got := testMerge(t, names, opts); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// with -newest, the second input's page 1 wins, all of its parts
+	old := *newest
+	*newest = true
+	defer func() { *newest = old }()
+	want = testDump(
+		testPage("1", "bbbbbbbbbb", "2001", "2002", "2003", "2004", "2005"),
+		testPage("2", "a", "2001"),
+		testPage("3", "b", "2001"),
+	)
+	if got := testMerge(t, names, opts); got != want {
+		t.Fatalf("with -newest, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeDeletions(t *testing.T) {
+	names := writeDumps(t,
+		testDump(testPage("1", "a", "2001"), testPage("2", "a", "2001", "2002", "2003", "2004"), testPage("3", "a", "2001")),
+		"3\n",
+		testDump("<logitem><timestamp>2003-06-01</timestamp><type>delete</type>"+
+			"<action>delete</action><logtitle>T2</logtitle></logitem>\n"+
+			"<logitem><timestamp>2005-06-01</timestamp><type>delete</type>"+
+			"<action>delete</action><logtitle>T1</logtitle></logitem>\n"),
+	)
+	old := deletions
+	defer func() { deletions = old }()
+	deletions = chunk.NewDeletionList()
+	for _, fn := range names[1:] {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deletions.Add(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// page 1 was deleted by title and 3 by ID, but page 2's last part has a
+	// revision from after its title was deleted
+	want := testDump(testPage("2", "a", "2001", "2002", "2003", "2004"))
+	if got := testMerge(t, names[:1], chunk.Options{MaxSegmentSize: 150}); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"html" // decoding entities, so a name hashes the same however it's escaped
+	"strconv"
+)
+
+/* ANONYMIZING CONTRIBUTORS
+
+An Anonymizer replaces what's in each <contributor> with pseudonyms, instead
+of cutting it out like -cutmeta: <username> and <ip> become tokens and <id>
+becomes another number, all from an HMAC-SHA256 of the original under a secret
+key. The same key gives the same pseudonyms in any dump, so you can still tell
+which edits came from the same person (and join dumps), but not who it was
+without the key.
+
+Only <contributor> is touched. Usernames can also show up in titles (User:
+pages), comments, and text; strip or drop those separately if they matter.
+
+*/
+
+type Anonymizer struct {
+	key []byte
+}
+
+func NewAnonymizer(key []byte) *Anonymizer {
+	return &Anonymizer{key: append([]byte(nil), key...)}
+}
+
+var contributorTag = []byte("<contributor")
+var contributorTagName = []byte("contributor")
+
+func (a *Anonymizer) sum(kind string, value []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(html.UnescapeString(string(value))))
+	return mac.Sum(nil)
+}
+
+// pseudonym appends the replacement for the content of a <username>, <ip>,
+// or <id>
+func (a *Anonymizer) pseudonym(name []byte, value []byte, out []byte) []byte {
+	sum := a.sum(string(name), value)
+	if string(name) == "id" {
+		id := binary.BigEndian.Uint64(sum) >> 1 // keep it a positive int64
+		return strconv.AppendUint(out, id, 10)
+	}
+	out = append(out, "Anon-"...)
+	return append(out, hex.EncodeToString(sum[:10])...)
+}
+
+// apply appends in, with contributors pseudonymized, to out.
+func (a *Anonymizer) apply(in []byte, out []byte) []byte {
+	pos := 0 // start of what we haven't copied yet
+	for {
+		i := bytes.Index(in[pos:], contributorTag)
+		if i == -1 {
+			break
+		}
+		start := pos + i
+		end := elementEnd(in[start:], contributorTagName)
+		if end == -1 { // truncated; leave it alone
+			break
+		}
+		end += start
+		out = append(out, in[pos:start]...)
+		out = a.contributor(in[start:end], out)
+		pos = end
+	}
+	return append(out, in[pos:]...)
+}
+
+// contributor appends a pseudonymized copy of a <contributor> element
+func (a *Anonymizer) contributor(in []byte, out []byte) []byte {
+	pos := 0
+	for i := 1; i < len(in); {
+		lt := bytes.IndexByte(in[i:], '<')
+		if lt == -1 {
+			break
+		}
+		start := i + lt
+		i = start + 1
+		name := tagName(in[start:])
+		switch string(name) {
+		case "username", "ip", "id":
+		default:
+			continue
+		}
+		tagEnd := bytes.IndexByte(in[start:], '>')
+		if tagEnd == -1 || in[start+tagEnd-1] == '/' {
+			continue
+		}
+		contentStart := start + tagEnd + 1
+		contentLen := bytes.IndexByte(in[contentStart:], '<')
+		if contentLen == -1 {
+			break
+		}
+		out = append(out, in[pos:contentStart]...)
+		out = a.pseudonym(name, in[contentStart:contentStart+contentLen], out)
+		pos = contentStart + contentLen
+		i = pos
+	}
+	return append(out, in[pos:]...)
+}
@@ -0,0 +1,142 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/twotwotwo/dltp/scan"
+)
+
+/* DELETION LISTS
+
+Adds-changes dumps don't say what was deleted, so a dump merged from them
+slowly fills up with pages that are gone from the wiki. A DeletionList lets
+-merge drop them. It's read from either
+
+  - a plain list of page IDs, one per line (blank lines and #comments are OK)
+  - a logging dump (pages-logging.xml), whose <logitem>s we look through for
+    deletions, suppressions, and undeletions
+
+Logging dumps name pages by title, not ID, so a logged deletion only counts
+against a page if the page's newest revision is older than the deletion. That
+way a page that was deleted and later recreated under the same title (with a
+new ID and new revisions) survives. An undeletion logged after a deletion
+cancels it.
+
+*/
+
+type DeletionList struct {
+	ids    map[int64]bool
+	titles map[string]string // XML-escaped title -> deletion timestamp
+}
+
+func NewDeletionList() *DeletionList {
+	return &DeletionList{ids: map[int64]bool{}, titles: map[string]string{}}
+}
+
+var logitemTag = []byte("<logitem>")
+var closeLogitemTag = []byte("</logitem>")
+
+// Add reads page IDs or a logging dump from r.
+func (d *DeletionList) Add(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+	start, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(start), []byte("<")) {
+		return d.addLog(br)
+	}
+	return d.addIDs(br)
+}
+
+func (d *DeletionList) addIDs(br *bufio.Reader) error {
+	lineNum := 0
+	for {
+		line, err := br.ReadString('\n')
+		lineNum++
+		if i := strings.IndexByte(line, '#'); i > -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			id, parseErr := strconv.ParseInt(line, 10, 64)
+			if parseErr != nil {
+				return fmt.Errorf("deletion list line %d: expected a page ID, got %q", lineNum, line)
+			}
+			d.ids[id] = true
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *DeletionList) addLog(r io.Reader) error {
+	in := scan.NewScanner(r, 1e6)
+	in.MaxBuffer = 1 << 26 // a <logitem> is small
+	for {
+		if in.ScanTo(logitemTag, false, true) == -1 {
+			return in.Err()
+		}
+		in.Discard()
+		if in.ScanTo(closeLogitemTag, true, false) == -1 {
+			return in.Err() // if nil, it was just truncated
+		}
+		item := in.Content()
+		logType, _ := Element(item, "type")
+		action, _ := Element(item, "action")
+		title, _ := Element(item, "logtitle")
+		timestamp, _ := Element(item, "timestamp")
+		if title != nil {
+			switch string(logType) + "/" + string(action) {
+			case "delete/delete", "delete/delete_redir", "suppress/delete":
+				if string(timestamp) > d.titles[string(title)] {
+					d.titles[string(title)] = string(timestamp)
+				}
+			case "delete/restore":
+				if string(timestamp) >= d.titles[string(title)] {
+					delete(d.titles, string(title))
+				}
+			}
+		}
+		in.Discard()
+	}
+}
+
+// ByTitle says whether a page (text is its first part) has a deletion logged
+// under its title, so Deleted needs its newest revision from all its parts.
+func (d *DeletionList) ByTitle(text []byte) bool {
+	if len(d.titles) == 0 {
+		return false
+	}
+	title, _ := Element(text, "title")
+	_, ok := d.titles[string(title)]
+	return ok
+}
+
+// Deleted says whether to drop a page, given its first part and the timestamp
+// of its newest revision in any part.
+func (d *DeletionList) Deleted(key SegmentKey, text []byte, newest []byte) bool {
+	if d.ids[key.ID()] {
+		return true
+	}
+	if len(d.titles) == 0 {
+		return false
+	}
+	title, _ := Element(text, "title")
+	deletedAt, ok := d.titles[string(title)]
+	if !ok {
+		return false
+	}
+	return string(newest) < deletedAt
+}
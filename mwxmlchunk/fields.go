@@ -0,0 +1,118 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"bytes"
+	"strconv"
+)
+
+/* PULLING FIELDS OUT OF SEGMENTS
+
+Quick-and-dirty lookups for when you need a field or two from a page (title,
+timestamp, etc.) and don't want to run encoding/xml over it. As with Strip,
+they count on '<' only showing up in markup. Values come back still
+XML-escaped.
+
+*/
+
+// Element finds the first <name ...>content</name> or <name ... /> in in,
+// returning the content (nil if it's empty) and the offset just past the
+// element (-1 if not found).
+func Element(in []byte, name string) (content []byte, end int) {
+	i := 0
+	for {
+		j := bytes.Index(in[i:], []byte("<"+name))
+		if j == -1 {
+			return nil, -1
+		}
+		i += j + 1 + len(name)
+		if i < len(in) && (in[i] == '>' || in[i] == ' ' || in[i] == '/') {
+			break
+		}
+	}
+	tagEnd := bytes.IndexByte(in[i:], '>')
+	if tagEnd == -1 {
+		return nil, -1
+	}
+	tagEnd += i
+	if in[tagEnd-1] == '/' {
+		return nil, tagEnd + 1
+	}
+	closeTag := "</" + name + ">"
+	closeIdx := bytes.Index(in[tagEnd:], []byte(closeTag))
+	if closeIdx == -1 {
+		return nil, -1
+	}
+	return in[tagEnd+1 : tagEnd+closeIdx], tagEnd + closeIdx + len(closeTag)
+}
+
+// ElementInt is Element for numbers (ns, id, etc.); 0 if there isn't one.
+func ElementInt(in []byte, name string) int64 {
+	content, _ := Element(in, name)
+	n, _ := strconv.ParseInt(string(content), 10, 64)
+	return n
+}
+
+// Attr gets an attribute from the tag starting at in[0], or nil.
+func Attr(in []byte, name string) []byte {
+	tagEnd := bytes.IndexByte(in, '>')
+	if tagEnd == -1 {
+		return nil
+	}
+	tag := in[:tagEnd]
+	i := bytes.Index(tag, []byte(" "+name+"=\""))
+	if i == -1 {
+		return nil
+	}
+	val := tag[i+len(name)+3:]
+	end := bytes.IndexByte(val, '"')
+	if end == -1 {
+		return nil
+	}
+	return val[:end]
+}
+
+var revisionTag = []byte("<revision>")
+
+// NewestRevision is the timestamp and ID of the newest <revision> in a segment
+// (latest timestamp, then highest ID), or nil and 0 if there isn't one.
+func NewestRevision(in []byte) (timestamp []byte, id int64) {
+	for {
+		i := bytes.Index(in, revisionTag)
+		if i == -1 {
+			return
+		}
+		in = in[i+len(revisionTag):]
+		// the revision's <id> comes before its contributor's
+		revID := ElementInt(in, "id")
+		ts, _ := Element(in, "timestamp")
+		if c := bytes.Compare(ts, timestamp); c > 0 || c == 0 && revID > id {
+			timestamp, id = ts, revID
+		}
+	}
+}
+
+var textTag = []byte("<text")
+
+// TextSize is the size of the last revision's text in a segment: its bytes=""
+// attribute if it has one, or else the length of its (still escaped) content.
+// It's -1 if there's no text at all.
+func TextSize(in []byte) int {
+	i := bytes.LastIndex(in, textTag)
+	for i > -1 {
+		end := i + len(textTag)
+		if end < len(in) && (in[end] == '>' || in[end] == ' ' || in[end] == '/') {
+			break
+		}
+		i = bytes.LastIndex(in[:i], textTag)
+	}
+	if i == -1 {
+		return -1
+	}
+	if size, err := strconv.Atoi(string(Attr(in[i:], "bytes"))); err == nil {
+		return size
+	}
+	content, _ := Element(in[i:], "text")
+	return len(content)
+}
@@ -4,10 +4,11 @@ package mwxmlchunk
 
 import (
 	//"github.com/twotwotwo/dltp/alloc"
-	"bytes"
+	"fmt"
 	"github.com/twotwotwo/dltp/scan"
 	sref "github.com/twotwotwo/dltp/sourceref"
 	"io"
+	"os"
 )
 
 /* WALKING THROUGH PAGES
@@ -18,62 +19,340 @@ ReadNext() -> text, key, sourceRef, err
 ReadTo(key) -> [same]
   reads 'til you reach a key (or pass over it, or reach EOF)
 
+If Options.MaxSegmentSize is set, a page bigger than that comes back as several
+segments ("parts"), split after a </revision>, so a huge history never has to
+be in memory at once. A single revision is never split, so a part can still go
+over the limit by up to a revision's length. Options.MinSize and MaxSize go by
+the page's last revision, which is in its last part, so with them the parts
+after the first are read ahead into a temp file until we know.
+
+Options.Element and Options.KeyTag let you read dumps made of something other
+than <page>s keyed by <id>: pages-logging dumps are <logitem>s keyed by <id>,
+and abstract dumps are <doc>s with no ID at all, so KeyTag "#" keys them by
+their position in the file instead. Everything below says "page" anyway.
+
+A SegmentKey holds the page ID in its high bits and the part number in its low
+bits, so the parts of a page sort after it and before the next page. Pages
+that aren't split are just part 0.
+
 */
 
 type SegmentKey int64
 
+const partBits = 20
+const maxPart = 1<<partBits - 1
+
 var StartKey SegmentKey = 0
 var PastEndKey SegmentKey = (1 << 63) - 1
 var BeforeStart SegmentKey = -PastEndKey
 
-var pageTag []byte = []byte("<page>")
-var closePageTag []byte = []byte("</page>")
+func PageKey(id int64, part int) SegmentKey {
+	return SegmentKey(id<<partBits | int64(part))
+}
+
+// the page ID from a key (meaningless for StartKey, PastEndKey, etc.)
+func (k SegmentKey) ID() int64 {
+	return int64(k) >> partBits
+}
+
+func (k SegmentKey) Part() int {
+	return int(k & maxPart)
+}
+
 var nsTag []byte = []byte("<ns>")
-var idTag []byte = []byte("<id>")
 var revTag []byte = []byte("<revision>")
-var revOrClosePageTags [][]byte = [][]byte{revTag, closePageTag}
+var closeRevTag []byte = []byte("</revision>")
+var redirectTag []byte = []byte("<redirect")
+
+// OrdinalKey as Options.KeyTag means to number elements in order.
+const OrdinalKey = "#"
+
+// Options say what a SegmentReader cuts out of its input and how it splits
+// it up. The zero value reads everything as-is.
+type Options struct {
+	LastRevOnly    bool
+	LimitToNS      bool
+	NS             int
+	NSName         string // if set, NS is looked up from <siteinfo> by name
+	Strip          *Strip
+	Anonymize      *Anonymizer
+	NoRedirects    bool
+	MinSize        int     // size of the last revision's text
+	MaxSize        int     // 0 means no limit
+	SampleRate     float64 // keep about this fraction of pages; 0 means all
+	SampleSeed     uint64
+	MaxSegmentSize int    // 0 means no limit
+	MaxBuffer      int    // most to buffer for a segment; 0 means no limit
+	Element        string // what each segment holds; "" means "page"
+	KeyTag         string // tag in Element with its key; "" means "id"
+}
+
+// Uncut is o without the cutting options, just the ones that say how input is
+// divided into segments; reading references with it keeps keys lined up.
+func (o Options) Uncut() Options {
+	return Options{
+		MaxSegmentSize: o.MaxSegmentSize,
+		MaxBuffer:      o.MaxBuffer,
+		Element:        o.Element,
+		KeyTag:         o.KeyTag,
+	}
+}
 
 type SegmentReader struct {
-	in           *scan.Scanner
-	currentSeg   []byte
-	backingSeg   []byte
-	nextKey      SegmentKey
-	currentKey   SegmentKey
-	offs         int64
-	sourceNumber int64
-	lastRevOnly  bool
-	limitToNS    bool
-	ns           int
-	cutMeta      bool
-}
-
-func NewSegmentReader(f io.Reader, sourceNumber int64, lastRevOnly bool, limitToNS bool, ns int, cutMeta bool) (s *SegmentReader) {
+	in                       *scan.Scanner
+	element                  string // for messages
+	keyName                  string
+	pageTag                  []byte
+	closePageTag             []byte
+	pageStart                *scan.Matcher // each lookup, compiled once
+	pageEnd                  *scan.Matcher
+	nsStart                  *scan.Matcher
+	keyStart                 *scan.Matcher // nil to number pages in order
+	revOrClosePage           *scan.Matcher
+	redirectOrRevOrClosePage *scan.Matcher
+	closeRevOrClosePage      *scan.Matcher
+	ordinal                  int64
+	currentSeg               []byte
+	backingSeg               []byte
+	nextKey                  SegmentKey
+	currentKey               SegmentKey
+	offs                     int64
+	sourceNumber             int64
+	lastRevOnly              bool
+	limitToNS                bool
+	noRedirects              bool
+	minSize                  int
+	maxSize                  int
+	firstPart                []byte // a split page's first part, while we return the rest
+	parts                    []spilledPart
+	partsRead                int      // how many of parts ReadNext has returned
+	partsFile                *os.File // the parts after the first
+	partsErr                 error    // what reading the last of them returned
+	partBuf                  []byte
+	sampleCutoff             uint64 // keep pages hashing below this, if nonzero
+	sampleSeed               uint64
+	ns                       int
+	nsName                   string
+	siteInfo                 *SiteInfo
+	strip                    *Strip
+	stripBuf                 []byte
+	anon                     *Anonymizer
+	anonBuf                  []byte
+	maxSegSize               int64
+	unsorted                 *UnsortedError // for the segment we've peeked at the key of
+}
+
+// An UnsortedError means a key (a page ID, unless Options say otherwise) was
+// the same as or lower than the one before it, so the input isn't sorted. ReadNext returns it along with the
+// segment that went backwards. ReadTo returns it as soon as it knows the next
+// segment goes backwards, since the key it's looking for could be past that.
+//
+// Whether that's a problem is up to the caller: merging, -split, and reading
+// a reference forward need sorted input and fail; -cut warns and goes on;
+// -sort expects it.
+type UnsortedError struct {
+	SourceNumber int64
+	Key          SegmentKey
+	PreviousKey  SegmentKey
+	Offset       int64
+	Element      string // as in Options, with defaults filled in
+	KeyTag       string
+}
+
+func (e *UnsortedError) Error() string {
+	keys := e.Element + " IDs"
+	if e.KeyTag != "id" {
+		keys = e.Element + " <" + e.KeyTag + ">s"
+	}
+	return fmt.Sprintf(
+		"%s out of order in source %d: %s %d follows %s %d (at byte %d)",
+		keys, e.SourceNumber, e.Element, e.Key.ID(), e.Element, e.PreviousKey.ID(), e.Offset,
+	)
+}
+
+func NewSegmentReader(f io.Reader, sourceNumber int64, opts Options) (s *SegmentReader) {
 	s = &SegmentReader{
 		in:           scan.NewScanner(f, 1e6),
 		sourceNumber: sourceNumber,
 		currentKey:   BeforeStart,
-		lastRevOnly:  lastRevOnly,
-		limitToNS:    limitToNS,
-		ns:           ns,
-		cutMeta:      cutMeta,
+		lastRevOnly:  opts.LastRevOnly,
+		limitToNS:    opts.LimitToNS,
+		noRedirects:  opts.NoRedirects,
+		minSize:      opts.MinSize,
+		maxSize:      opts.MaxSize,
+		sampleSeed:   opts.SampleSeed,
+		ns:           opts.NS,
+		nsName:       opts.NSName,
+		strip:        opts.Strip,
+		anon:         opts.Anonymize,
+		maxSegSize:   int64(opts.MaxSegmentSize),
+	}
+	element, keyTag := opts.Element, opts.KeyTag
+	if element == "" {
+		element = "page"
+	}
+	if keyTag == "" {
+		keyTag = "id"
+	}
+	s.element, s.keyName = element, keyTag
+	s.pageTag = []byte("<" + element + ">")
+	s.closePageTag = []byte("</" + element + ">")
+	s.pageStart = scan.NewMatcher(s.pageTag)
+	s.pageEnd = scan.NewMatcher(s.closePageTag)
+	s.nsStart = scan.NewMatcher(nsTag)
+	s.revOrClosePage = scan.NewMatcher(revTag, s.closePageTag)
+	s.redirectOrRevOrClosePage = scan.NewMatcher(redirectTag, revTag, s.closePageTag)
+	s.closeRevOrClosePage = scan.NewMatcher(closeRevTag, s.closePageTag)
+	if keyTag != OrdinalKey {
+		s.keyStart = scan.NewMatcher([]byte("<" + keyTag + ">"))
+	}
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		s.sampleCutoff = uint64(opts.SampleRate * (1 << 64))
 	}
+	s.in.MaxBuffer = opts.MaxBuffer
 	s.currentSeg = make([]byte, 0, 1e6)
 	return
 }
 
+// for Options.SampleRate: splitmix64's mixing function. it only depends on the
+// ID and seed, so samples of different dumps have the same pages.
+func sampleHash(id int64, seed uint64) uint64 {
+	z := uint64(id) + seed*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// scan through the rest of a page, but stop after a </revision> if we've
+// gone past maxSegSize; split says whether we did that
+func (s *SegmentReader) scanPagePart(startOffs int64) (endOffs int64, split bool) {
+	tag := []byte(nil)
+	for {
+		endOffs, tag = s.in.ScanToMatch(s.closeRevOrClosePage, true, false)
+		if endOffs == -1 || &tag[0] == &s.closePageTag[0] {
+			return endOffs, false
+		}
+		if endOffs-startOffs >= s.maxSegSize && s.nextKey.Part() < maxPart {
+			return endOffs, true
+		}
+	}
+}
+
+// a part of a split page, saved in partsFile by spillParts
+type spilledPart struct {
+	key SegmentKey
+	sr  sref.SourceRef
+	n   int
+}
+
 func (s *SegmentReader) ReadNext() (text []byte, key SegmentKey, sr sref.SourceRef, err error) {
+	if s.partsRead < len(s.parts) {
+		return s.nextSpilledPart()
+	}
+	if s.minSize == 0 && s.maxSize == 0 {
+		return s.readNext()
+	}
+	// we only know a page's size once we've read it, so filter here
+	pending := error(nil) // an UnsortedError from a page we skipped
+	for {
+		text, key, sr, err = s.readNext()
+		if _, ok := err.(*UnsortedError); ok {
+			pending, err = err, nil
+		}
+		if err != nil || key == StartKey || key == PastEndKey {
+			break
+		}
+		size := TextSize(text)
+		if s.nextKey.Part() != 0 && s.nextKey != PastEndKey {
+			// the newest revision is in the last part, so read ahead to it
+			text, size, err = s.spillParts(text, size)
+			if err != nil {
+				break
+			}
+		}
+		if size >= s.minSize && (s.maxSize == 0 || size <= s.maxSize) {
+			break
+		}
+		s.parts = s.parts[:0]
+		if s.partsErr != nil { // the page we skipped ran into EOF or an error
+			text, key, sr, err = nil, s.currentKey, sref.InvalidSource, s.partsErr
+			break
+		}
+	}
+	if err == nil {
+		err = pending
+	}
+	return
+}
+
+// spillParts reads the rest of a page that was split into parts, saving them
+// to a temp file for ReadNext to return after the first, and returns a copy of
+// the first part (whose buffer reading reuses) and the size of the text of the
+// page's last revision.
+func (s *SegmentReader) spillParts(first []byte, size int) ([]byte, int, error) {
+	s.firstPart = append(s.firstPart[:0], first...)
+	if s.partsFile == nil {
+		f, err := os.CreateTemp("", "dltp-page-*.xml")
+		if err != nil {
+			return nil, 0, err
+		}
+		os.Remove(f.Name()) // we only need it while it's open
+		s.partsFile = f
+	}
+	if _, err := s.partsFile.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+	s.parts, s.partsRead, s.partsErr = s.parts[:0], 0, nil
+	for s.partsErr == nil && s.nextKey.Part() != 0 && s.nextKey != PastEndKey {
+		text, key, sr, err := s.readNext()
+		s.partsErr = err
+		if _, werr := s.partsFile.Write(text); werr != nil {
+			return nil, 0, werr
+		}
+		s.parts = append(s.parts, spilledPart{key, sr, len(text)})
+		// the last part can be just the </page>, with no revision in it
+		if partSize := TextSize(text); partSize != -1 {
+			size = partSize
+		}
+	}
+	if _, err := s.partsFile.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+	return s.firstPart, size, nil
+}
+
+// nextSpilledPart returns the next part spillParts saved, and with the last
+// one, whatever error reading it got
+func (s *SegmentReader) nextSpilledPart() (text []byte, key SegmentKey, sr sref.SourceRef, err error) {
+	p := s.parts[s.partsRead]
+	s.partsRead++
+	if cap(s.partBuf) < p.n {
+		s.partBuf = make([]byte, p.n)
+	}
+	text = s.partBuf[:p.n]
+	if _, err = io.ReadFull(s.partsFile, text); err != nil {
+		return nil, p.key, p.sr, err
+	}
+	if s.partsRead == len(s.parts) {
+		err = s.partsErr
+	}
+	return text, p.key, p.sr, err
+}
+
+func (s *SegmentReader) readNext() (text []byte, key SegmentKey, sr sref.SourceRef, err error) {
 	startOffs := s.in.Offs
 	s.currentSeg = s.backingSeg[:0]
 	tag := []byte(nil)
+	split := false
 	var endOffs int64
 	if s.nextKey == PastEndKey { // EOF--stop at NOTHING
 		endOffs = -1
 	} else if s.nextKey == StartKey { // start of file--stop before <page>
-		endOffs = s.in.ScanTo(pageTag, false, false)
+		endOffs, _ = s.in.ScanToMatch(s.pageStart, false, false)
 	} else { // normal--stop after </page>
 		if s.lastRevOnly {
 			// we've only read up to id -- find either <revision> or </page>
-			endOffs, tag = s.in.ScanToAny(revOrClosePageTags, true, false)
+			endOffs, tag = s.in.ScanToMatch(s.revOrClosePage, true, false)
 			if endOffs == -1 {
 				// not expected, but recoverable: file truncated in page metadata
 			} else {
@@ -86,11 +365,13 @@ func (s *SegmentReader) ReadNext() (text []byte, key SegmentKey, sr sref.SourceR
 				for tag != nil && &tag[0] == &revTag[0] {
 					s.in.Discard()
 					startOffs = s.in.Offs
-					endOffs, tag = s.in.ScanToAny(revOrClosePageTags, true, false)
+					endOffs, tag = s.in.ScanToMatch(s.revOrClosePage, true, false)
 				}
 			}
+		} else if s.maxSegSize > 0 {
+			endOffs, split = s.scanPagePart(startOffs)
 		} else {
-			endOffs = s.in.ScanTo(closePageTag, true, false)
+			endOffs, _ = s.in.ScanToMatch(s.pageEnd, true, false)
 		}
 	}
 
@@ -100,49 +381,112 @@ func (s *SegmentReader) ReadNext() (text []byte, key SegmentKey, sr sref.SourceR
 		s.nextKey = PastEndKey
 		endOffs = startOffs + int64(len(s.in.All))
 		err = io.EOF
+		if s.in.Err() != nil { // not a real EOF
+			err = s.in.Err()
+		}
 	}
 
 	s.currentSeg = append(s.currentSeg, s.in.All[:endOffs-startOffs]...)
-	// cutMeta will return a slice in the middle of the segment, so save the
-	// true start of it to reuse later.
+	// currentSeg may end up pointing at stripBuf or anonBuf, so save the
+	// buffer to reuse
 	s.backingSeg = s.currentSeg
-	if s.cutMeta {
-		s.currentSeg = cutMeta(s.currentSeg)
-	}
 	s.in.Discard()
 
-	text = s.currentSeg
-	key = s.currentKey
-	if s.lastRevOnly {
+	if s.lastRevOnly || s.strip != nil || s.anon != nil {
 		// the text we return doesn't correspond to any input
 		sr = sref.InvalidSource
 	} else {
 		sr = sref.SourceRef{s.sourceNumber, uint64(startOffs), uint64(len(s.currentSeg))}
 	}
+	// the preamble has nothing we'd strip
+	if s.strip != nil && s.currentKey != StartKey {
+		s.stripBuf = s.strip.apply(s.currentSeg, s.stripBuf[:0])
+		s.currentSeg = s.stripBuf
+	}
+	if s.anon != nil && s.currentKey != StartKey {
+		s.anonBuf = s.anon.apply(s.currentSeg, s.anonBuf[:0])
+		s.currentSeg = s.anonBuf
+	}
 
-	// get next ns, skipping page it's not "ours"
-	if s.limitToNS {
-		for {
-			nsTagOffs := s.in.ScanTo(nsTag, true, false)
-			if nsTagOffs == -1 {
-				s.nextKey = PastEndKey
+	text = s.currentSeg
+	key = s.currentKey
+	if key == StartKey {
+		s.siteInfo, _ = ParseSiteInfo(text) // fine if there's none
+		if s.limitToNS && s.nsName != "" {
+			ok := false
+			if s.siteInfo != nil {
+				s.ns, ok = s.siteInfo.NamespaceKey(s.nsName)
+			}
+			if !ok {
+				err = fmt.Errorf("no namespace named '%s' in this dump's <siteinfo>", s.nsName)
 				return
 			}
-			ns := s.in.PeekInt()
-			if ns == s.ns {
-				break
+		}
+	}
+	if s.unsorted != nil {
+		err = s.unsorted
+		s.unsorted = nil
+	}
+
+	// we're in the middle of a page, so the next segment is just the next part
+	if split {
+		s.nextKey = s.currentKey + 1
+		return
+	}
+
+	for {
+		// get next ns, skipping page it's not "ours"
+		if s.limitToNS {
+			for {
+				nsTagOffs, _ := s.in.ScanToMatch(s.nsStart, true, false)
+				if nsTagOffs == -1 {
+					s.nextKey = PastEndKey
+					return
+				}
+				ns := s.in.PeekInt()
+				if ns == s.ns {
+					break
+				}
+				// cleanly discard this page and go on, without buffering it
+				s.in.ScanToMatch(s.pageEnd, true, true)
+				s.in.Discard()
 			}
-			// cleanly discard this page and go on
-			s.in.ScanTo(closePageTag, true, false)
+		}
+
+		// get next id; set EOF flag if we have to
+		idTagOffs := int64(0)
+		if s.keyStart == nil {
+			idTagOffs, _ = s.in.ScanToMatch(s.pageStart, true, false)
+			s.ordinal++
+			s.nextKey = PageKey(s.ordinal, 0)
+		} else {
+			idTagOffs, _ = s.in.ScanToMatch(s.keyStart, true, false)
+			s.nextKey = PageKey(int64(s.in.PeekInt()), 0)
+		}
+		if idTagOffs == -1 {
+			s.nextKey = PastEndKey
+			break
+		}
+
+		if s.sampleCutoff != 0 && sampleHash(s.nextKey.ID(), s.sampleSeed) >= s.sampleCutoff {
+			s.in.ScanToMatch(s.pageEnd, true, true)
 			s.in.Discard()
+			continue
 		}
-	}
 
-	// get next id; set EOF flag if we have to
-	idTagOffs := s.in.ScanTo(idTag, true, false)
-	s.nextKey = SegmentKey(s.in.PeekInt())
-	if idTagOffs == -1 {
-		s.nextKey = PastEndKey
+		// skip redirects; <redirect> comes after the id, before any revision
+		if s.noRedirects {
+			offs, tag := s.in.ScanToMatch(s.redirectOrRevOrClosePage, false, false)
+			if offs != -1 && &tag[0] == &redirectTag[0] {
+				s.in.ScanToMatch(s.pageEnd, true, true)
+				s.in.Discard()
+				continue
+			}
+		}
+		break
+	}
+	if s.nextKey <= s.currentKey && s.currentKey != StartKey && s.nextKey != PastEndKey {
+		s.unsorted = &UnsortedError{s.sourceNumber, s.nextKey, s.currentKey, s.in.Offs, s.element, s.keyName}
 	}
 
 	return
@@ -160,6 +504,11 @@ func (s *SegmentReader) ReadTo(key SegmentKey) (text []byte, reachedKey SegmentK
 	if reachedKey == key { // success! pretty much
 		return
 	}
+	// we went past key, but the next page goes backwards, so it could still
+	// be ahead of us
+	if err == nil && s.unsorted != nil {
+		err = s.unsorted
+	}
 
 	// failed to find! which could happen at EOF, or earlier if incr includes
 	// a page ID that was skipped over in the reference (which should be rare,
@@ -169,42 +518,15 @@ func (s *SegmentReader) ReadTo(key SegmentKey) (text []byte, reachedKey SegmentK
 	return
 }
 
-func cutBetween(in []byte, start []byte, end []byte) []byte {
-	startIdx := bytes.Index(in, start)
-	if startIdx > -1 {
-		endIdx := bytes.Index(in, end)
-		if endIdx >= startIdx {
-			endIdx += len(end)
-			bytesCut := endIdx - startIdx
-			// unusual to move the front forward, instead of the end back, but we
-			// know there's much less content before our target strings than after
-			copy(in[bytesCut:], in[:startIdx])
-			return in[bytesCut:]
-		}
-	}
-	return in
+// SiteInfo is the parsed <siteinfo> from the preamble, or nil if we haven't
+// read it yet or couldn't find or parse it.
+func (s *SegmentReader) SiteInfo() *SiteInfo {
+	return s.siteInfo
 }
 
 func (s *SegmentReader) Close() error {
+	if s.partsFile != nil {
+		s.partsFile.Close()
+	}
 	return s.in.Close()
 }
-
-var commentTag = []byte("      <comment>")
-var commentCloseTag = []byte("</comment>\n")
-var contributorTag = []byte("      <contributor>")
-var contributorCloseTag = []byte("      </contributor>\n")
-var minorTag = []byte("      <minor />\n")
-var textStart = []byte("<text")
-
-func cutMeta(in []byte) []byte {
-	metaEnd := bytes.Index(in, textStart)
-	if metaEnd == -1 {
-		return in
-	}
-	meta := in[:metaEnd]
-	meta = cutBetween(meta, commentTag, commentCloseTag)
-	meta = cutBetween(meta, contributorTag, contributorCloseTag)
-	meta = cutBetween(meta, minorTag, minorTag)
-	bytesCut := metaEnd - len(meta)
-	return in[bytesCut:]
-}
@@ -0,0 +1,187 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testDump(ids ...string) string {
+	dump := "<mediawiki>\n"
+	for _, id := range ids {
+		dump += "  <page>\n    <title>T" + id + "</title>\n    <id>" + id + "</id>\n  </page>\n"
+	}
+	return dump + "</mediawiki>\n"
+}
+
+func TestUnsorted(t *testing.T) {
+	// ReadNext: the error comes with the page that went backwards
+	r := NewSegmentReader(strings.NewReader(testDump("1", "9", "5")), 0, Options{})
+	for _, want := range []int64{-1, 1, 9, 5} {
+		_, key, _, err := r.ReadNext()
+		if want == -1 {
+			continue // preamble
+		}
+		if key.ID() != want {
+			t.Fatalf("got page %d, want %d", key.ID(), want)
+		}
+		if _, unsorted := err.(*UnsortedError); unsorted != (want == 5) {
+			t.Fatalf("page %d: got err %v", want, err)
+		}
+	}
+
+	// ReadTo: page 5 is still ahead when we pass it, so it mustn't come back
+	// as just not found
+	r = NewSegmentReader(strings.NewReader(testDump("1", "9", "5")), 0, Options{})
+	text, _, _, err := r.ReadTo(PageKey(5, 0))
+	if _, ok := err.(*UnsortedError); !ok || text != nil {
+		t.Fatalf("ReadTo past a backwards step: got %q, %v", text, err)
+	}
+
+	// sorted input with a gap is just not found
+	r = NewSegmentReader(strings.NewReader(testDump("1", "9", "12")), 0, Options{})
+	text, key, _, err := r.ReadTo(PageKey(5, 0))
+	if err != nil || text != nil || key.ID() != 9 {
+		t.Fatalf("ReadTo a missing page: got %q, page %d, %v", text, key.ID(), err)
+	}
+
+	// the error names what we're keying on
+	items := "<mediawiki>\n  <item><n>2</n></item>\n  <item><n>1</n></item>\n</mediawiki>\n"
+	r = NewSegmentReader(strings.NewReader(items), 0, Options{Element: "item", KeyTag: "n"})
+	for err == nil {
+		_, _, _, err = r.ReadNext()
+	}
+	want := "item <n>s out of order in source 0: item 1 follows item 2"
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("got error %q, want it to start %q", err, want)
+	}
+}
+
+func TestDeletions(t *testing.T) {
+	d := NewDeletionList()
+	err := d.Add(strings.NewReader("<mediawiki>\n" +
+		"<logitem><timestamp>2020-01-01T00:00:00Z</timestamp><type>delete</type><action>delete</action><logtitle>T5</logtitle></logitem>\n" +
+		"</mediawiki>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Add(strings.NewReader("7\n")); err != nil {
+		t.Fatal(err)
+	}
+	page := []byte("<page>\n    <title>T5</title>\n    <id>5</id>\n    <revision><timestamp>2019-01-01T00:00:00Z</timestamp></revision>\n")
+	if !d.ByTitle(page) || d.ByTitle([]byte("<title>T6</title>")) {
+		t.Fatal("ByTitle doesn't match the logged title")
+	}
+	key := PageKey(5, 0)
+	if !d.Deleted(key, page, []byte("2019-01-01T00:00:00Z")) {
+		t.Fatal("page older than its deletion was kept")
+	}
+	// recreated after the deletion, in a later part
+	if d.Deleted(key, page, []byte("2021-01-01T00:00:00Z")) {
+		t.Fatal("page with a revision newer than its deletion was dropped")
+	}
+	if !d.Deleted(PageKey(7, 0), []byte("<title>T7</title>"), nil) {
+		t.Fatal("listed page ID was kept")
+	}
+}
+
+func TestSkipLargePages(t *testing.T) {
+	page := func(id string, ns string, extra string) string {
+		return "  <page>\n    <title>T" + id + "</title>\n    <ns>" + ns + "</ns>\n    <id>" + id + "</id>\n" +
+			extra + "    <revision><text>" + id + "</text></revision>\n  </page>\n"
+	}
+	huge := "    <revision><text>" + strings.Repeat("x", 5000) + "</text></revision>\n"
+	// page 2 is bigger than MaxBuffer, but each option skips it, so it never
+	// needs buffering (a 50% sample with seed 0 has pages 1 and 3, not 2)
+	for name, c := range map[string]struct {
+		opts  Options
+		page2 string
+	}{
+		"-ns":          {Options{LimitToNS: true}, page("2", "1", huge)},
+		"-sample":      {Options{SampleRate: 0.5}, page("2", "0", huge)},
+		"-noredirects": {Options{NoRedirects: true}, page("2", "0", "    <redirect title=\"T1\" />\n"+huge)},
+	} {
+		c.opts.MaxBuffer = 2000
+		dump := "<mediawiki>\n" + page("1", "0", "") + c.page2 + page("3", "0", "") + "</mediawiki>\n"
+		r := NewSegmentReader(strings.NewReader(dump), 0, c.opts)
+		ids := []int64{}
+		for {
+			_, key, _, err := r.ReadNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if key != StartKey {
+				ids = append(ids, key.ID())
+			}
+		}
+		if fmt.Sprint(ids) != "[1 3]" {
+			t.Fatalf("%s: got pages %v, want [1 3]", name, ids)
+		}
+	}
+}
+
+func TestSizeFilterParts(t *testing.T) {
+	page := func(id string, sizes ...int) string {
+		page := "<page>\n    <title>T" + id + "</title>\n    <id>" + id + "</id>\n"
+		for _, size := range sizes {
+			page += "    <revision>\n      <text bytes=\"" + fmt.Sprint(size) + "\">" + strings.Repeat("x", size) + "</text>\n    </revision>\n"
+		}
+		return page + "  </page>"
+	}
+	// pages 1 and 2 get split into parts, and only their last revisions'
+	// sizes count, not the last in the first part
+	pages := map[int64]string{
+		1: page("1", 10, 10, 10, 10, 200),
+		2: page("2", 200, 200, 200, 200, 10),
+		3: page("3", 100),
+	}
+	dump := "<mediawiki>\n  " + pages[1] + "\n  " + pages[2] + "\n  " + pages[3] + "\n</mediawiki>\n"
+	for _, c := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{MinSize: 100}, "[1 3]"},
+		{Options{MaxSize: 100}, "[2 3]"},
+		{Options{MinSize: 50, MaxSize: 150}, "[3]"},
+	} {
+		c.opts.MaxSegmentSize = 150
+		r := NewSegmentReader(strings.NewReader(dump), 0, c.opts)
+		ids, got, parts := []int64{}, map[int64]string{}, 0
+		for {
+			text, key, _, err := r.ReadNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key == StartKey {
+				continue
+			}
+			if key.Part() == 0 {
+				ids = append(ids, key.ID())
+			} else {
+				parts++
+			}
+			got[key.ID()] += string(text)
+		}
+		r.Close()
+		if fmt.Sprint(ids) != c.want {
+			t.Fatalf("min %d, max %d: got pages %v, want %s", c.opts.MinSize, c.opts.MaxSize, ids, c.want)
+		}
+		for _, id := range ids {
+			if strings.TrimSpace(got[id]) != pages[id] {
+				t.Fatalf("page %d: got:\n%s\nwant:\n%s", id, got[id], pages[id])
+			}
+		}
+		if parts == 0 && ids[0] != 3 {
+			t.Fatal("pages weren't split; make them bigger")
+		}
+	}
+}
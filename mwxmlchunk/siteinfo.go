@@ -0,0 +1,83 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+/* SITEINFO
+
+The preamble (the StartKey segment) has a <siteinfo> describing the wiki,
+including its namespace table. It's small, so unlike pages we're happy to run
+encoding/xml over it. SegmentReader.SiteInfo() gives you the parsed version
+once the preamble's been read.
+
+*/
+
+type Namespace struct {
+	Key  int    `xml:"key,attr"`
+	Case string `xml:"case,attr"`
+	Name string `xml:",chardata"` // "" for the main namespace
+}
+
+type SiteInfo struct {
+	SiteName   string      `xml:"sitename"`
+	DBName     string      `xml:"dbname"`
+	Base       string      `xml:"base"`
+	Generator  string      `xml:"generator"`
+	Case       string      `xml:"case"`
+	Namespaces []Namespace `xml:"namespaces>namespace"`
+}
+
+var siteInfoTag = []byte("<siteinfo>")
+var closeSiteInfoTag = []byte("</siteinfo>")
+
+var ErrNoSiteInfo = errors.New("no <siteinfo> in dump preamble")
+
+// ParseSiteInfo parses the <siteinfo> out of a dump's preamble.
+func ParseSiteInfo(preamble []byte) (*SiteInfo, error) {
+	start := bytes.Index(preamble, siteInfoTag)
+	end := bytes.Index(preamble, closeSiteInfoTag)
+	if start == -1 || end < start {
+		return nil, ErrNoSiteInfo
+	}
+	si := &SiteInfo{}
+	err := xml.Unmarshal(preamble[start:end+len(closeSiteInfoTag)], si)
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// NamespaceName gives the name of a namespace ("" for main) and whether the
+// wiki has it.
+func (si *SiteInfo) NamespaceName(key int) (name string, ok bool) {
+	for _, ns := range si.Namespaces {
+		if ns.Key == key {
+			return ns.Name, true
+		}
+	}
+	return "", false
+}
+
+// NamespaceKey looks up a namespace by name the way MediaWiki does titles:
+// ignoring case and treating _ as a space. "" and "Main" get you namespace 0.
+func (si *SiteInfo) NamespaceKey(name string) (key int, ok bool) {
+	name = strings.TrimSpace(strings.Replace(name, "_", " ", -1))
+	if name == "" {
+		return 0, true
+	}
+	for _, ns := range si.Namespaces {
+		if strings.EqualFold(ns.Name, name) {
+			return ns.Key, true
+		}
+	}
+	if strings.EqualFold(name, "main") {
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,204 @@
+// Public domain, dltp contributors, 2026
+
+package mwxmlchunk
+
+import (
+	"bytes"
+	"errors"
+	"html" // decoding entities, to count text bytes
+	"strconv"
+	"strings"
+)
+
+/* STRIPPING ELEMENTS
+
+A Strip is a set of elements to cut out of pages and revisions (-strip, or
+-cutmeta, which is shorthand for comment,contributor,minor). It can also empty
+out <text> (textbody, or -notext), which leaves a dump like Wikimedia's stub
+dumps: <text bytes="123" /> instead of the text itself.
+
+Since text in the dump is XML-escaped, any '<' in a segment starts real markup,
+so we can find elements by jumping from '<' to '<' without parsing anything.
+Elements are dropped along with their indentation and trailing newline, so the
+output is still valid MediaWiki XML and looks like the exporter wrote it.
+
+*/
+
+type Strip struct {
+	names     map[string]bool
+	textAttrs bool
+	textBody  bool
+}
+
+// StripNames lists what -strip accepts; textattrs means "drop the attributes
+// of <text>" and textbody "drop the text but keep <text>" rather than an
+// element.
+var StripNames = []string{
+	"comment", "contributor", "minor", "sha1", "model", "format",
+	"parentid", "redirect", "restrictions", "origin", "textattrs", "textbody",
+}
+
+var CutMetaNames = []string{"comment", "contributor", "minor"}
+
+// NewStrip takes a list of names from StripNames; it returns nil (meaning
+// strip nothing) for an empty list.
+func NewStrip(names []string) (st *Strip, err error) {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, n := range StripNames {
+			if n == name {
+				known = true
+			}
+		}
+		if !known {
+			return nil, errors.New("can't strip '" + name + "'; choose from " + strings.Join(StripNames, ", "))
+		}
+		if st == nil {
+			st = &Strip{names: map[string]bool{}}
+		}
+		if name == "textattrs" {
+			st.textAttrs = true
+		} else if name == "textbody" {
+			st.textBody = true
+		} else {
+			st.names[name] = true
+		}
+	}
+	return
+}
+
+var textTagName = []byte("text")
+
+// tagName returns the name of the tag starting at in[0] (a '<'), or nil for
+// closing tags, comments, etc.
+func tagName(in []byte) []byte {
+	for i := 1; i < len(in); i++ {
+		switch in[i] {
+		case ' ', '\t', '\n', '>', '/':
+			if i == 1 {
+				return nil
+			}
+			return in[1:i]
+		}
+	}
+	return nil
+}
+
+// elementEnd returns the offset just past the element whose start tag is at
+// in[0], or -1 if it's truncated.
+func elementEnd(in []byte, name []byte) int {
+	tagEnd := bytes.IndexByte(in, '>')
+	if tagEnd == -1 {
+		return -1
+	}
+	if in[tagEnd-1] == '/' { // <minor />, <contributor deleted="deleted" />
+		return tagEnd + 1
+	}
+	closeTag := make([]byte, 0, len(name)+3)
+	closeTag = append(append(append(closeTag, "</"...), name...), '>')
+	closeIdx := bytes.Index(in[tagEnd:], closeTag)
+	if closeIdx == -1 {
+		return -1
+	}
+	return tagEnd + closeIdx + len(closeTag)
+}
+
+// apply appends in, minus the stripped elements, to out.
+func (st *Strip) apply(in []byte, out []byte) []byte {
+	pos := 0 // start of what we haven't copied yet
+	for i := 0; i < len(in); {
+		lt := bytes.IndexByte(in[i:], '<')
+		if lt == -1 {
+			break
+		}
+		start := i + lt
+		name := tagName(in[start:])
+		i = start + 1
+		if name == nil {
+			continue
+		}
+
+		if (st.textAttrs || st.textBody) && bytes.Equal(name, textTagName) {
+			tagEnd := bytes.IndexByte(in[start:], '>')
+			if tagEnd == -1 {
+				break
+			}
+			tagEnd += start
+			selfClosing := in[tagEnd-1] == '/'
+			end := tagEnd + 1
+			if st.textBody && !selfClosing {
+				end = elementEnd(in[start:], name)
+				if end == -1 { // truncated; leave it alone
+					break
+				}
+				end += start
+			}
+			out = append(out, in[pos:start]...)
+			if st.textBody {
+				out = st.emptyText(in[start:end], out)
+			} else if selfClosing {
+				out = append(out, "<text />"...)
+			} else {
+				out = append(out, "<text>"...)
+			}
+			pos = end
+			i = pos
+			continue
+		}
+
+		if !st.names[string(name)] {
+			continue
+		}
+		end := elementEnd(in[start:], name)
+		if end == -1 { // truncated; leave it alone
+			break
+		}
+		end += start
+
+		// take the indentation and newline too, if the element had its own line
+		lineStart := start
+		for lineStart > pos && (in[lineStart-1] == ' ' || in[lineStart-1] == '\t') {
+			lineStart--
+		}
+		if (lineStart == 0 || in[lineStart-1] == '\n') && end < len(in) && in[end] == '\n' {
+			start = lineStart
+			end++
+		}
+
+		out = append(out, in[pos:start]...)
+		pos = end
+		i = end
+	}
+	return append(out, in[pos:]...)
+}
+
+var bytesAttr = []byte(" bytes=\"")
+var spaceAttr = []byte(" xml:space=\"preserve\"")
+
+// emptyText appends a self-closing version of the <text> element in text,
+// like a stub dump has: same attributes, minus xml:space, plus bytes="" if it
+// didn't say how big the text was.
+func (st *Strip) emptyText(text []byte, out []byte) []byte {
+	out = append(out, "<text"...)
+	if !st.textAttrs {
+		tagEnd := bytes.IndexByte(text, '>')
+		attrs := bytes.TrimRight(text[len("<text"):tagEnd], " /")
+		if i := bytes.Index(attrs, spaceAttr); i > -1 {
+			out = append(out, attrs[:i]...)
+			out = append(out, attrs[i+len(spaceAttr):]...)
+		} else {
+			out = append(out, attrs...)
+		}
+		if !bytes.Contains(attrs, bytesAttr) && text[tagEnd-1] != '/' {
+			content, _ := Element(text, "text")
+			out = append(out, bytesAttr...)
+			out = strconv.AppendInt(out, int64(len(html.UnescapeString(string(content)))), 10)
+			out = append(out, '"')
+		}
+	}
+	return append(out, " />"...)
+}
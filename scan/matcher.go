@@ -0,0 +1,96 @@
+// Public domain, dltp contributors, 2026
+
+package scan
+
+import (
+	"bytes"
+)
+
+/*
+
+MULTI-PATTERN MATCHING
+
+A Matcher finds whichever of several patterns comes first in a buffer in one
+pass, instead of one bytes.Index per pattern. It jumps between bytes that can
+start a pattern with IndexByte (or IndexAny, if the patterns start with
+different bytes), then checks the patterns starting with that byte. The tags
+we look for all start with '<', and in a dump '<' only shows up in markup
+(text is escaped), so the jumps skip right over revision text.
+
+Compile a Matcher once (NewMatcher) and reuse it; ScanToMatch takes one.
+SegmentReader compiles one for each lookup it does when it's created, even the
+single-tag ones, which just use bytes.Index. ScanToAny keeps the last Matcher
+it compiled, and reuses it when it's passed the same patterns again.
+
+*/
+
+type Matcher struct {
+	patterns [][]byte
+	byFirst  [256][]int // indexes of patterns starting with each byte
+	firsts   string     // every byte that starts a pattern
+	ascii    bool       // firsts are all ASCII, so IndexAny works on them
+	maxLen   int
+}
+
+// NewMatcher compiles a set of patterns. When two match at the same spot,
+// the one listed first wins. Patterns can't be empty.
+func NewMatcher(patterns ...[]byte) *Matcher {
+	m := &Matcher{patterns: patterns, ascii: true}
+	for i, p := range patterns {
+		if len(p) == 0 {
+			panic("empty pattern in NewMatcher")
+		}
+		c := p[0]
+		if m.byFirst[c] == nil {
+			m.firsts += string([]byte{c})
+			m.ascii = m.ascii && c < 0x80
+		}
+		m.byFirst[c] = append(m.byFirst[c], i)
+		if len(p) > m.maxLen {
+			m.maxLen = len(p)
+		}
+	}
+	return m
+}
+
+// Index returns the offset of the first match in b and the pattern that
+// matched (the same slice passed to NewMatcher), or -1 and nil.
+func (m *Matcher) Index(b []byte) (int, []byte) {
+	if len(m.patterns) == 1 { // bytes.Index is as fast as it gets
+		if i := bytes.Index(b, m.patterns[0]); i > -1 {
+			return i, m.patterns[0]
+		}
+		return -1, nil
+	}
+	for i := 0; i < len(b); i++ {
+		next := -1
+		if len(m.firsts) == 1 {
+			next = bytes.IndexByte(b[i:], m.firsts[0])
+		} else if m.ascii {
+			next = bytes.IndexAny(b[i:], m.firsts)
+		} else {
+			for j, c := range b[i:] {
+				if m.byFirst[c] != nil {
+					next = j
+					break
+				}
+			}
+		}
+		if next == -1 {
+			return -1, nil
+		}
+		i += next
+		for _, p := range m.byFirst[b[i]] {
+			if bytes.HasPrefix(b[i:], m.patterns[p]) {
+				return i, m.patterns[p]
+			}
+		}
+	}
+	return -1, nil
+}
+
+// overlap is how much of the end of a buffer could be the start of a match
+// that continues into the next one
+func (m *Matcher) overlap() int {
+	return m.maxLen - 1
+}
@@ -0,0 +1,123 @@
+// Public domain, dltp contributors, 2026
+
+package scan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// the old way: one bytes.Index per pattern
+func naiveIndex(b []byte, patterns [][]byte) (int, []byte) {
+	i, a := -1, []byte(nil)
+	for _, p := range patterns {
+		j := bytes.Index(b, p)
+		if j != -1 && (i == -1 || j < i) {
+			i, a = j, p
+		}
+	}
+	return i, a
+}
+
+func TestMatcherIndex(t *testing.T) {
+	for _, patterns := range [][][]byte{
+		{[]byte("<revision>"), []byte("</page>"), []byte("<rev"), []byte("x\xffy")},
+		{[]byte("</page>")}, // just bytes.Index
+	} {
+		m := NewMatcher(patterns...)
+		for _, in := range []string{
+			"",
+			"no tags here",
+			"<page><title>a</title><revision>x</revision></page>",
+			"<rev <revision>",
+			"text &lt;revision&gt; </page",
+			"ab x\xffy <revision>",
+			"</pag</page>",
+		} {
+			i, a := m.Index([]byte(in))
+			wantI, wantA := naiveIndex([]byte(in), patterns)
+			if i != wantI || !bytes.Equal(a, wantA) {
+				t.Errorf("%q: got %d %q, want %d %q", in, i, a, wantI, wantA)
+			}
+		}
+	}
+}
+
+func TestScanToAny(t *testing.T) {
+	s := NewScanner(strings.NewReader("<a> <b> <c> <b>"), 1e6)
+	ab := [][]byte{[]byte("<a>"), []byte("<b>")}
+	_, tag := s.ScanToAny(ab, true, true)
+	m := s.anyMatcher
+	_, tag2 := s.ScanToAny(ab, true, true)
+	if &tag[0] != &ab[0][0] || &tag2[0] != &ab[1][0] {
+		t.Fatalf("got %q and %q, not the slices passed in", tag, tag2)
+	}
+	if s.anyMatcher != m {
+		t.Fatal("recompiled the same patterns")
+	}
+	// different patterns get a new Matcher
+	_, tag = s.ScanToAny([][]byte{[]byte("<c>"), []byte("<b>")}, true, true)
+	if string(tag) != "<c>" || s.anyMatcher == m {
+		t.Fatalf("got %q with the old Matcher %v", tag, s.anyMatcher == m)
+	}
+}
+
+func TestScanToMatchAcrossFills(t *testing.T) {
+	// a pattern straddling the scanner's buffer boundary still gets found
+	in := strings.Repeat("a", 1000) + "</page>" + strings.Repeat("b", 10) + "<revision>"
+	s := NewScanner(strings.NewReader(in), 1003)
+	m := NewMatcher([]byte("<revision>"), []byte("</page>"))
+	offs, tag := s.ScanToMatch(m, true, true)
+	if offs != 1007 || string(tag) != "</page>" {
+		t.Errorf("got %d %q, want 1007 </page>", offs, tag)
+	}
+	offs, tag = s.ScanToMatch(m, false, true)
+	if offs != 1017 || string(tag) != "<revision>" {
+		t.Errorf("got %d %q, want 1017 <revision>", offs, tag)
+	}
+	offs, tag = s.ScanToMatch(m, true, true)
+	if offs != 1027 || string(tag) != "<revision>" {
+		t.Errorf("got %d %q, want 1027 <revision>", offs, tag)
+	}
+	offs, tag = s.ScanToMatch(m, true, true)
+	if offs != -1 || tag != nil {
+		t.Errorf("got %d %q at EOF, want -1 and nil", offs, tag)
+	}
+}
+
+var benchText = []byte(strings.Repeat(
+	"    <revision>\n      <id>1</id>\n      <timestamp>2024-01-01T00:00:00Z</timestamp>\n"+
+		"      <text bytes=\"900\" xml:space=\"preserve\">"+strings.Repeat("some wikitext [[link]] &lt;ref&gt; ", 30)+
+		"</text>\n    </revision>\n", 200) + "  </page>")
+
+var benchPatterns = [][]byte{[]byte("<redirect"), []byte("</revision>"), []byte("</page>")}
+
+func BenchmarkMatcher(b *testing.B) {
+	m := NewMatcher(benchPatterns...)
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		rest := benchText
+		for {
+			j, a := m.Index(rest)
+			if j == -1 {
+				break
+			}
+			rest = rest[j+len(a):]
+		}
+	}
+}
+
+func BenchmarkNaive(b *testing.B) {
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		rest := benchText
+		for {
+			j, a := naiveIndex(rest, benchPatterns)
+			if j == -1 {
+				break
+			}
+			rest = rest[j+len(a):]
+		}
+	}
+}
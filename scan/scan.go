@@ -4,6 +4,7 @@ package scan
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 )
 
@@ -13,6 +14,10 @@ SCANNING
 
 read through a stream up to a given string, and peek at integers
 
+when something goes wrong (a read error, or needing more than MaxBuffer bytes
+to hold what we've scanned since the last Discard) the Scanner acts as if it
+hit EOF, and Err() says what happened.
+
 */
 
 type Scanner struct {
@@ -25,23 +30,61 @@ type Scanner struct {
 	Offs int64
 	// And this covers everything allocated
 	backing []byte
+	// If nonzero, the most we'll buffer; see TooLargeError
+	MaxBuffer int
+	err       error
+	// ScanToAny's last patterns, compiled
+	anyChoices [][]byte
+	anyMatcher *Matcher
+}
+
+// TooLargeError means a segment (whatever was scanned since the last Discard)
+// needed more than MaxBuffer bytes, usually because the tag ending it is
+// missing.
+type TooLargeError struct {
+	Offset    int64 // where the segment started
+	MaxBuffer int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf(
+		"segment too large at offset %d (over %d bytes; truncated or malformed input?)",
+		e.Offset, e.MaxBuffer,
+	)
 }
 
 // fill s.All with more data--return bytes read in, or -1 if no data was
 // available. may expand the buffer or move data around in it.
 func (s *Scanner) fill() int64 {
+	if s.err != nil {
+		return -1
+	}
+	if s.MaxBuffer > 0 && len(s.All) >= s.MaxBuffer {
+		s.err = &TooLargeError{s.Offs, s.MaxBuffer}
+		return -1
+	}
 	if len(s.All) == cap(s.All) { // no room
+		newCap := cap(s.backing) * 2
+		if s.MaxBuffer > 0 && newCap > s.MaxBuffer {
+			newCap = s.MaxBuffer
+		}
 		old := s.All
-		s.All = make([]byte, len(s.All), cap(s.backing)*2)
+		s.All = make([]byte, len(s.All), newCap)
 		s.backing = s.All
 		copy(s.All, old)
 	}
-	c, err := s.in.Read(s.All[len(s.All):cap(s.All)])
+	// MaxBuffer can be less than the buffer we started with
+	end := cap(s.All)
+	if s.MaxBuffer > 0 && end > s.MaxBuffer {
+		end = s.MaxBuffer
+	}
+	c, err := s.in.Read(s.All[len(s.All):end])
 	s.All = s.All[:len(s.All)+c]
 	s.unread = s.All[s.unreadOffs-s.Offs:]
 	if err != nil {
 		if err != io.EOF {
-			panic(err)
+			s.err = fmt.Errorf("read error at offset %d: %s", s.Offs+int64(len(s.All)), err)
+			return -1
 		}
 		if c == 0 {
 			return -1
@@ -50,6 +93,11 @@ func (s *Scanner) fill() int64 {
 	return int64(c)
 }
 
+// Err is what stopped the Scanner early, or nil if it's fine or reached EOF.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
 // mark data read
 func (s *Scanner) consume(length int) { // 386: segments need to be <2GB (OK)
 	s.unread = s.unread[length:]
@@ -98,22 +146,36 @@ func (s *Scanner) ScanTo(a []byte, inclusive bool, discard bool) int64 {
 }
 
 // look for whichever of a set of sequences comes up first in the stream.
-// we depend on this returning the same byte slice passed in.
+// we depend on this returning the same byte slice passed in. the Matcher is
+// only recompiled when the choices aren't the same slices as last call's, so
+// don't change their contents in between.
 func (s *Scanner) ScanToAny(aChoices [][]byte, inclusive bool, discard bool) (int64, []byte) {
-	i := -1
-	a := []byte(nil)
-	overlap := 0
+	if s.anyMatcher == nil || !sameSlices(aChoices, s.anyChoices) {
+		s.anyChoices = append(s.anyChoices[:0], aChoices...)
+		s.anyMatcher = NewMatcher(s.anyChoices...)
+	}
+	return s.ScanToMatch(s.anyMatcher, inclusive, discard)
+}
 
+// sameSlices says whether a and b hold the same slices (not just equal bytes)
+func sameSlices(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) || len(a[i]) > 0 && &a[i][0] != &b[i][0] {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanToAny with a precompiled Matcher
+func (s *Scanner) ScanToMatch(m *Matcher, inclusive bool, discard bool) (int64, []byte) {
+	overlap := m.overlap()
 	for {
 		// look for the sequence that appears first in the buffer
-		for _, myA := range aChoices {
-			myI := bytes.Index(s.unread, myA)
-			// not the most elegant if cond
-			if myI != -1 && (i == -1 || myI < i) {
-				i = myI
-				a = myA
-			}
-		}
+		i, a := m.Index(s.unread)
 		// if found, return where we ended up
 		if i != -1 {
 			if inclusive {
@@ -123,14 +185,7 @@ func (s *Scanner) ScanToAny(aChoices [][]byte, inclusive bool, discard bool) (in
 			return s.unreadOffs, a
 		}
 
-		// determine how much overlap to keep, then fill buffer
-		if overlap == 0 {
-			for _, myA := range aChoices {
-				if len(myA)-1 > overlap {
-					overlap = len(myA) - 1
-				}
-			}
-		}
+		// keep enough to catch a sequence straddling the fill, then fill buffer
 		if len(s.unread) > overlap {
 			s.consume(len(s.unread) - overlap)
 			if discard {
@@ -148,14 +203,14 @@ func (s *Scanner) ScanToAny(aChoices [][]byte, inclusive bool, discard bool) (in
 			}
 			return c, nil
 		}
-
 	}
 }
 
 /*
- * consumeLimited and LimitedScan are for a so-far-hypothetical mode where we
- * compress an *entire* revision history but break it into largish chunks (say,
- * 10MB at a go) to save memory. tl;dr: not used.
+ * consumeLimited and LimitedScan were for breaking an entire revision history
+ * into largish chunks to save memory. SegmentReader ended up splitting after a
+ * </revision> instead (see Options.MaxSegmentSize), so that anything cutting
+ * or converting the parts sees whole revisions. tl;dr: not used.
  */
 
 // consume bytes, respecting a limit,
@@ -266,7 +321,7 @@ func NewScanner(r io.Reader, cap int) (s *Scanner) {
 		backing: buf,
 		unread:  buf,
 	}
-	s.fill()
+	// the first scan fills the buffer, after the caller's set MaxBuffer
 	return
 }
 
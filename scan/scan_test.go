@@ -0,0 +1,34 @@
+// Public domain, dltp contributors, 2026
+
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxBuffer(t *testing.T) {
+	in := "<page>" + strings.Repeat("x", 5000) + "</page>"
+	// both less than and more than the 1000 bytes we start with
+	for _, max := range []int{100, 4000} {
+		s := NewScanner(strings.NewReader(in), 1000)
+		s.MaxBuffer = max
+		if offs := s.ScanTo([]byte("</page>"), true, false); offs != -1 {
+			t.Fatalf("MaxBuffer %d: found </page> at %d", max, offs)
+		}
+		err, ok := s.Err().(*TooLargeError)
+		if !ok || err.Offset != 0 || err.MaxBuffer != max {
+			t.Fatalf("MaxBuffer %d: got error %v", max, s.Err())
+		}
+		if len(s.All) > max {
+			t.Fatalf("MaxBuffer %d: buffered %d bytes", max, len(s.All))
+		}
+	}
+
+	// a buffer just big enough is fine
+	s := NewScanner(strings.NewReader(in), 1000)
+	s.MaxBuffer = len(in)
+	if offs := s.ScanTo([]byte("</page>"), true, false); offs != int64(len(in)) || s.Err() != nil {
+		t.Fatalf("MaxBuffer %d: got %d, %v", len(in), offs, s.Err())
+	}
+}
@@ -0,0 +1,174 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+/*
+
+SORTING
+
+-sort reads a dump from stdin and writes it to stdout sorted by page ID, which
+-merge and packing need. Where a page ID shows up more than once, the last copy
+wins.
+
+It's an external sort: we collect pages until we have -sortmem worth, sort
+them, and write them out as a "run" (a little sorted dump in a temp file), then
+merge the runs like -merge would. Later runs take precedence, so later copies
+of a page still win. If everything fits in one run, we skip the temp file.
+
+We only start a new run between pages, so the parts of a page split by
+-maxsegment all end up in the same run; that means one run can go over
+-sortmem by about the size of the biggest page.
+
+*/
+
+var sortDump = flag.Bool("sort", false, "sort stdin by page ID to stdout (later copies of a page win)")
+var sortMem = flag.String("sortmem", "256MB", "with -sort, how much to sort in memory before using temp files")
+
+type sortEntry struct {
+	key        chunk.SegmentKey
+	occurrence int // which copy of the page (for duplicates)
+	start, end int // in the run's text
+}
+
+type sortRun struct {
+	text    []byte
+	entries []sortEntry
+}
+
+// sort by page, newest copy first, then by part
+func (r *sortRun) Len() int      { return len(r.entries) }
+func (r *sortRun) Swap(i, j int) { r.entries[i], r.entries[j] = r.entries[j], r.entries[i] }
+func (r *sortRun) Less(i, j int) bool {
+	a, b := r.entries[i], r.entries[j]
+	if a.key.ID() != b.key.ID() {
+		return a.key.ID() < b.key.ID()
+	}
+	if a.occurrence != b.occurrence {
+		return a.occurrence > b.occurrence
+	}
+	return a.key < b.key
+}
+
+// write sorts the run and writes it as a dump, with only the last copy of
+// each page
+func (r *sortRun) write(out io.Writer, preamble []byte, trailer []byte) {
+	sort.Sort(r)
+	w := bufio.NewWriter(out)
+	w.Write(preamble)
+	// segments start with the indentation (from the preamble) before a page,
+	// except the input's first page; keep the output looking the same
+	indent := []byte("\n")
+	if i := bytes.LastIndexByte(preamble, '\n'); i > -1 {
+		indent = append(indent, preamble[i+1:]...)
+	}
+	first := true
+	kept := sortEntry{key: chunk.BeforeStart}
+	for _, e := range r.entries {
+		if e.key.ID() == kept.key.ID() && e.occurrence != kept.occurrence {
+			continue // an older copy of the page
+		}
+		kept = e
+		text := r.text[e.start:e.end]
+		if e.key.Part() == 0 {
+			text = bytes.TrimLeft(text, " \t\r\n")
+			if !first {
+				w.Write(indent)
+			}
+			first = false
+		}
+		w.Write(text)
+	}
+	w.Write(trailer)
+	err := w.Flush()
+	if err != nil {
+		panic(err)
+	}
+	r.text, r.entries = r.text[:0], r.entries[:0]
+}
+
+// SortStdinToStdout sorts a dump by page ID.
+func SortStdinToStdout() {
+	memLimit := sizeFlag("sortmem", *sortMem)
+	r := chunk.NewSegmentReader(stdinReader(), 0, cutOpts)
+	run := &sortRun{}
+	runNames := []string(nil)
+	defer func() {
+		for _, fn := range runNames {
+			os.Remove(fn)
+		}
+	}()
+	var preamble, trailer []byte
+	spill := func() {
+		f, err := os.CreateTemp("", "dltp-sort-*.xml")
+		if err != nil {
+			panic(err)
+		}
+		runNames = append(runNames, f.Name())
+		run.write(f, preamble, trailer)
+		err = f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+	occurrence := 0
+	for {
+		text, key, _, err := r.ReadNext()
+		if _, ok := err.(*chunk.UnsortedError); ok { // that's why we're here
+			err = nil
+		}
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		if key == chunk.StartKey {
+			preamble = append([]byte(nil), text...)
+			trailer = rootTrailer(preamble)
+		} else if key != chunk.PastEndKey {
+			if key.Part() == 0 {
+				if len(run.text) >= memLimit {
+					spill()
+				}
+				occurrence++
+			}
+			run.entries = append(run.entries, sortEntry{key, occurrence, len(run.text), len(run.text) + len(text)})
+			run.text = append(run.text, text...)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	r.Close()
+	if preamble == nil {
+		quitWith("no dump on stdin to sort")
+	}
+
+	out := convertOutput(os.Stdout)
+	if runNames == nil {
+		run.write(out, preamble, trailer)
+	} else {
+		spill()
+		// newest run first, so it takes precedence
+		names := make([]string, len(runNames))
+		labels := make([]string, len(runNames))
+		for i, fn := range runNames {
+			names[len(runNames)-1-i] = fn
+			labels[len(runNames)-1-i] = fmt.Sprint("sort run ", i+1)
+		}
+		mergeFiles(names, labels, nil, cutOpts.Uncut(), out, true)
+	}
+	err := out.Close()
+	if err != nil {
+		panic(err)
+	}
+}
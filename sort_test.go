@@ -0,0 +1,76 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSort runs dump through SortStdinToStdout with the given -sortmem and
+// -maxsegment
+func testSort(t *testing.T, dump string, mem string, maxSegment int) string {
+	dir := t.TempDir()
+	inName, outName := filepath.Join(dir, "in.xml"), filepath.Join(dir, "out.xml")
+	if err := os.WriteFile(inName, []byte(dump), 0666); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(inName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(outName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdin, oldStdout, oldMem, oldOpts := os.Stdin, os.Stdout, *sortMem, cutOpts
+	defer func() {
+		in.Close()
+		out.Close()
+		os.Stdin, os.Stdout, *sortMem, cutOpts = oldStdin, oldStdout, oldMem, oldOpts
+	}()
+	os.Stdin, os.Stdout, *sortMem = in, out, mem
+	cutOpts.MaxSegmentSize = maxSegment
+
+	SortStdinToStdout()
+
+	sorted, err := os.ReadFile(outName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(sorted)
+}
+
+func TestSort(t *testing.T) {
+	dump := testDump(
+		testPage("5", "first", "2001"),
+		testPage("2", "a", "2001"),
+		testPage("9", "a", "2001", "2002", "2003", "2004"),
+		testPage("5", "second", "2001", "2002"),
+		testPage("1", "a", "2001"),
+		testPage("5", "last", "2003"),
+		testPage("3", "a", "2001"),
+	)
+	want := testDump(
+		testPage("1", "a", "2001"),
+		testPage("2", "a", "2001"),
+		testPage("3", "a", "2001"),
+		testPage("5", "last", "2003"),
+		testPage("9", "a", "2001", "2002", "2003", "2004"),
+	)
+	for _, c := range []struct {
+		mem        string
+		maxSegment int
+	}{
+		{"256MB", 0}, // all in one run
+		{"1", 0},     // a run per page, merged
+		{"400", 0},   // duplicates within and across runs
+		{"1", 150},   // split pages, whose parts all have to go in one run
+		{"400", 150},
+	} {
+		if got := testSort(t, dump, c.mem, c.maxSegment); got != want {
+			t.Fatalf("-sortmem %s -maxsegment %d: got:\n%s\nwant:\n%s", c.mem, c.maxSegment, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Public domain, dltp contributors, 2026
+
+package spillfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+
+SPILLING STREAMS TO DISK
+
+A decompressor only reads forward, but packing against an unsorted reference
+(or unpacking something that refers back into one) needs ReadAt anywhere. A
+SpillFile copies a stream into a file as it's read, in the background, and
+serves Read and ReadAt from the file; like an HTTPFile, asking for bytes that
+aren't there yet blocks until they are.
+
+New spills to a temp file that goes away when you're done. NewCached keeps
+the copy, named by CacheName after the compressed file's path, size, and
+modification time, so the next run can open the copy instead of decompressing
+again. (Hashing the compressed file itself would mean reading all of it before
+we could start.) Closing a cached SpillFile before the copy's done waits for it
+to finish, since a run usually only needs part of a reference, and a partial
+copy would be no use to the next one.
+
+*/
+
+type SpillFile struct {
+	src    io.Reader
+	f      *os.File
+	fileMu sync.RWMutex // finish swaps f for the renamed file under reads
+	name   string       // to remove on Close, if we couldn't remove it already
+	keep   string       // if caching, what to rename f to once it's complete
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	available int64
+	done      bool // set once the copy's finished (or failed, or was stopped)
+	closed    bool
+	err       error // from reading src or writing f
+
+	readOffs int64
+}
+
+// New starts spilling src to a temp file in dir ("" for the system's temp
+// directory).
+func New(src io.Reader, dir string) (*SpillFile, error) {
+	f, err := os.CreateTemp(dir, "dltp-spill-*.xml")
+	if err != nil {
+		return nil, err
+	}
+	sf := start(src, f, "")
+	// on Unix the file can go now and stick around until we close it; if
+	// that's not allowed, Close will remove it
+	if os.Remove(f.Name()) != nil {
+		sf.name = f.Name()
+	}
+	return sf, nil
+}
+
+// CacheName is where a decompressed copy of compressedPath goes in dir. It's
+// named after a hash of compressedPath's absolute path, size, and modification
+// time, so a changed or replaced file won't match an old copy.
+func CacheName(compressedPath string, dir string) (string, error) {
+	abs, err := filepath.Abs(compressedPath)
+	if err != nil {
+		return "", err
+	}
+	stat, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d", abs, stat.Size(), stat.ModTime().UnixNano())
+	hash := hex.EncodeToString(h.Sum(nil)[:16])
+	base := filepath.Base(compressedPath)
+	for ext := filepath.Ext(base); ext != "" && ext != ".xml"; ext = filepath.Ext(base) {
+		base = base[:len(base)-len(ext)]
+	}
+	return filepath.Join(dir, hash+"-"+base), nil
+}
+
+// NewCached starts spilling src to cacheName (from CacheName). It's written
+// to a temp file next to it and renamed once it's complete, so if cacheName
+// exists you can just open it, and two runs filling the cache at once don't
+// trip over each other.
+func NewCached(src io.Reader, cacheName string) (*SpillFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(cacheName), filepath.Base(cacheName)+".partial-*")
+	if err != nil {
+		return nil, err
+	}
+	return start(src, f, cacheName), nil
+}
+
+var ErrClosed = errors.New("spill file already closed")
+
+func start(src io.Reader, f *os.File, keep string) *SpillFile {
+	sf := &SpillFile{src: src, f: f, keep: keep}
+	sf.cond = sync.NewCond(&sf.mu)
+	go sf.copy()
+	return sf
+}
+
+func (sf *SpillFile) copy() {
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := sf.src.Read(buf)
+		if n > 0 {
+			_, werr := sf.f.Write(buf[:n])
+			if werr != nil && err == nil {
+				err = werr
+			}
+		}
+		sf.mu.Lock()
+		sf.available += int64(n)
+		stop := err != nil || sf.closed && sf.keep == ""
+		if stop {
+			if err != io.EOF {
+				sf.err = err
+			}
+			sf.finish()
+			sf.done = true
+		}
+		sf.mu.Unlock()
+		sf.cond.Broadcast()
+		if stop {
+			return
+		}
+	}
+}
+
+// finish closes the source and, if we're caching, puts the copy in place (or
+// throws it away if the source failed); call with mu held
+func (sf *SpillFile) finish() {
+	if c, ok := sf.src.(io.Closer); ok {
+		c.Close()
+	}
+	if sf.keep == "" {
+		return
+	}
+	name := sf.f.Name()
+	if sf.err != nil {
+		if os.Remove(name) != nil {
+			sf.name = name // try again once it's closed
+		}
+		return
+	}
+
+	// some systems won't rename an open file, so close it, rename it, and
+	// reopen it for reading
+	sf.fileMu.Lock()
+	defer sf.fileMu.Unlock()
+	if err := sf.f.Close(); err != nil {
+		sf.err = err
+		return
+	}
+	if err := os.Rename(name, sf.keep); err != nil {
+		// the copy's still fine to read; we just can't keep it
+		fmt.Fprintln(os.Stderr, "Warning: can't save decompressed copy:", err)
+		sf.name = name
+	} else {
+		name = sf.keep
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		sf.err = err
+		return
+	}
+	sf.f = f
+}
+
+// waitFor blocks until offs is available or the copy's done, and returns the
+// copy's error if it had one.
+func (sf *SpillFile) waitFor(offs int64) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for sf.available < offs && !sf.done {
+		sf.cond.Wait()
+	}
+	if sf.closed {
+		return ErrClosed
+	}
+	return sf.err
+}
+
+func (sf *SpillFile) ReadAt(p []byte, off int64) (n int, err error) {
+	err = sf.waitFor(off + int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	sf.fileMu.RLock()
+	defer sf.fileMu.RUnlock()
+	return sf.f.ReadAt(p, off)
+}
+
+func (sf *SpillFile) Read(p []byte) (n int, err error) {
+	n, err = sf.ReadAt(p, sf.readOffs)
+	sf.readOffs += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// Close stops the copy and removes the temp file, or if we're caching, waits
+// for the copy to finish and be put in place.
+func (sf *SpillFile) Close() error {
+	sf.mu.Lock()
+	sf.closed = true
+	if sf.keep != "" && !sf.done {
+		fmt.Fprintln(os.Stderr, "Finishing the decompressed copy in", sf.keep, "for next time")
+	}
+	for !sf.done {
+		sf.cond.Wait()
+	}
+	sf.mu.Unlock()
+	err := sf.f.Close()
+	if sf.name != "" {
+		os.Remove(sf.name)
+	}
+	return err
+}
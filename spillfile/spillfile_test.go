@@ -0,0 +1,179 @@
+// Public domain, dltp contributors, 2026
+
+package spillfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// endless is a source that never runs out
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestReadAtBeforeDone(t *testing.T) {
+	pr, pw := io.Pipe()
+	sf, err := New(pr, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	pw.Write(data[:500])
+
+	// the first half is there, so we don't wait for the rest
+	p := make([]byte, 100)
+	if n, err := sf.ReadAt(p, 300); n != 100 || err != nil || !bytes.Equal(p, data[300:400]) {
+		t.Fatalf("ReadAt(100 bytes, 300): got %d bytes, %v", n, err)
+	}
+
+	// the second half isn't, so we do
+	got := make(chan error)
+	go func() {
+		_, err := sf.ReadAt(p, 800)
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("ReadAt past the copy returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pw.Write(data[500:])
+	pw.Close()
+	if err := <-got; err != nil || !bytes.Equal(p, data[800:900]) {
+		t.Fatalf("ReadAt(100 bytes, 800): %v", err)
+	}
+	all, err := io.ReadAll(sf)
+	if err != nil || !bytes.Equal(all, data) {
+		t.Fatalf("Read: got %d bytes, %v", len(all), err)
+	}
+}
+
+func TestCached(t *testing.T) {
+	dir := t.TempDir()
+	compressed := filepath.Join(dir, "enwiki-pages.xml.bz2")
+	if err := os.WriteFile(compressed, []byte("compressed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cacheName, err := CacheName(compressed, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := CacheName(compressed, dir); again != cacheName {
+		t.Fatalf("CacheName changed from %s to %s", cacheName, again)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	sf, err := NewCached(bytes.NewReader(data), cacheName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 10)
+	if _, err = sf.ReadAt(p, int64(len(data))-10); err != nil {
+		t.Fatal(err)
+	}
+	// once the copy's in place, reads still work
+	if _, err = sf.ReadAt(p, 0); err != nil || !bytes.Equal(p, data[:10]) {
+		t.Fatalf("ReadAt after the copy finished: %v", err)
+	}
+	if err = sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := os.ReadFile(cacheName)
+	if err != nil || !bytes.Equal(cached, data) {
+		t.Fatalf("cached copy: %d bytes, %v", len(cached), err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(names) != 2 {
+		t.Fatalf("left behind %v", names)
+	}
+
+	// a changed file gets a new name
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(compressed, later, later)
+	if changed, _ := CacheName(compressed, dir); changed == cacheName {
+		t.Fatal("CacheName didn't change with the file's modification time")
+	}
+}
+
+func TestCloseFinishesCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheName := filepath.Join(dir, "copy.xml")
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	// closed partway through, the copy still gets finished and kept
+	pr, pw := io.Pipe()
+	sf, err := NewCached(pr, cacheName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write(data[:500])
+	sf.ReadAt(make([]byte, 10), 100)
+	closed := make(chan error)
+	go func() { closed <- sf.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned before the copy was done: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pw.Write(data[500:])
+	pw.Close()
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sf.ReadAt(make([]byte, 10), 0); err != ErrClosed {
+		t.Fatalf("ReadAt after Close: got %v", err)
+	}
+	cached, err := os.ReadFile(cacheName)
+	if err != nil || !bytes.Equal(cached, data) {
+		t.Fatalf("cached copy: %d bytes, %v", len(cached), err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 1 {
+		t.Fatalf("left behind %v", names)
+	}
+
+	// a plain spill file stops copying instead
+	sf, err = New(endless{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.ReadAt(make([]byte, 10), 1000)
+	if err := sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncompleteDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	cacheName := filepath.Join(dir, "copy.xml")
+
+	// the source failed
+	pr, pw := io.Pipe()
+	sf, err := NewCached(pr, cacheName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write([]byte("some of it"))
+	broken := errors.New("corrupt input")
+	pw.CloseWithError(broken)
+	if _, err := sf.ReadAt(make([]byte, 10), 100); err != broken {
+		t.Fatalf("ReadAt past a failed copy: got %v", err)
+	}
+	sf.Close()
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(names) != 0 {
+		t.Fatalf("incomplete copy left behind: %v", names)
+	}
+}
@@ -0,0 +1,215 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/twotwotwo/dltp/zip"
+
+	chunk "github.com/twotwotwo/dltp/mwxmlchunk"
+)
+
+/*
+
+SPLITTING
+
+-split N or -split-size 2GB streams a dump into several files, each a complete
+dump with the preamble (<siteinfo> and all) and closing tag, covering a range
+of page IDs. They're named like Wikimedia's split dumps, after the first and
+last page in them, e.g., enwiki-20240101-pages-articles-p1p41242.xml.bz2, and
+go in the current directory. We print each name when it's done, so you can
+pipe the list to whatever runs the per-shard jobs.
+
+-split-size counts uncompressed bytes. -split N aims for shards of about equal
+size by watching how far we are through the input file, so it needs a file,
+not stdin; a compressed input file gets compressed shards, as if you'd passed
+-zip with its format.
+
+A page is never divided between shards, even if it's bigger than the shard
+size; cutting options (-lastrev, -ns, etc.) apply before we measure anything.
+
+*/
+
+var splitCount = flag.Int("split", 0, "split a dump into this many files by page ID range")
+var splitSize = flag.String("split-size", "", "split a dump into files of about this size (e.g., 2GB), uncompressed")
+
+// counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+type shardWriter struct {
+	base        string // output name, minus the page range and suffixes
+	compression string
+	tempName    string
+	f           *os.File
+	zOut        io.WriteCloser
+	w           *bufio.Writer
+	written     int64
+	firstID     int64
+	lastID      int64
+}
+
+func (s *shardWriter) open(preamble []byte, firstID int64) {
+	s.tempName = s.base + ".partial"
+	f, err := os.Create(s.tempName)
+	if err != nil {
+		panic(err)
+	}
+	s.f = f
+	s.zOut = nopCloser{f}
+	if s.compression != "" {
+		s.zOut = zip.NewWriter(f, s.compression)
+	}
+	s.w = bufio.NewWriter(s.zOut)
+	s.written, s.firstID = 0, firstID
+	s.write(preamble)
+}
+
+func (s *shardWriter) write(text []byte) {
+	_, err := s.w.Write(text)
+	if err != nil {
+		panic(err)
+	}
+	s.written += int64(len(text))
+}
+
+// close writes the trailer and renames the file for its page range
+func (s *shardWriter) close(trailer []byte) {
+	s.write(trailer)
+	err := s.w.Flush()
+	if err == nil {
+		err = s.zOut.Close()
+	}
+	if err == nil {
+		err = s.f.Close()
+	}
+	if err != nil {
+		panic(err)
+	}
+	name := fmt.Sprintf("%s-p%dp%d.xml", s.base, s.firstID, s.lastID)
+	if s.compression != "" {
+		name += "." + zip.FormatOf(s.compression)
+	}
+	err = os.Rename(s.tempName, name)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(name)
+	s.f = nil
+}
+
+// rootTrailer makes a closing tag for the root element the preamble opens
+func rootTrailer(preamble []byte) []byte {
+	for i := 0; i < len(preamble); i++ {
+		if preamble[i] != '<' || i+1 == len(preamble) || preamble[i+1] == '?' || preamble[i+1] == '!' {
+			continue
+		}
+		end := bytes.IndexAny(preamble[i+1:], " \t\r\n/>")
+		if end == -1 {
+			break
+		}
+		return []byte("\n</" + string(preamble[i+1:i+1+end]) + ">\n")
+	}
+	panic("can't find the dump's root element to split it")
+}
+
+// Split splits the named dump, or stdin if name is "", into shards.
+func Split(name string) {
+	in := io.Reader(os.Stdin)
+	base := "dump"
+	progress := (*countingReader)(nil)
+	inSize := int64(0)
+	if name != "" {
+		f, err := os.Open(name)
+		if err != nil {
+			quitWith("can't open %s: %s", name, err)
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			panic(err)
+		}
+		inSize = stat.Size()
+		progress = &countingReader{r: f}
+		in = progress
+		base = strings.TrimSuffix(path.Base(filepath.Base(zip.UnzippedName(name))), ".xml")
+	}
+	in, format, err := zip.NewSniffingReader(in)
+	if err != nil {
+		quitWith("can't read %s: %s", name, err)
+	}
+	if *compression == "auto" {
+		*compression = format
+	}
+
+	maxSize := int64(0)
+	if *splitSize != "" {
+		var err error
+		maxSize, err = parseSize(*splitSize)
+		if err != nil || maxSize == 0 {
+			quitWith("-split-size: can't understand size '%s'", *splitSize)
+		}
+	}
+
+	r := chunk.NewSegmentReader(in, 0, cutOpts)
+	shard := &shardWriter{base: base, compression: *compression}
+	shardsStarted := 0
+	var preamble, trailer []byte
+	for {
+		text, key, _, err := r.ReadNext()
+		if _, ok := err.(*chunk.UnsortedError); ok {
+			panic(fmt.Sprint("input isn't sorted, which -split needs: ", err))
+		}
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		if key == chunk.StartKey {
+			preamble = append([]byte(nil), text...)
+			trailer = rootTrailer(preamble)
+		} else if key != chunk.PastEndKey {
+			if key.Part() == 0 && shard.f != nil && shard.written > int64(len(preamble)) {
+				full := false
+				if maxSize > 0 {
+					full = shard.written+int64(len(text)) > maxSize
+				} else if shardsStarted < *splitCount {
+					full = progress.n >= int64(shardsStarted)*inSize/int64(*splitCount)
+				}
+				if full {
+					shard.close(trailer)
+				}
+			}
+			if shard.f == nil {
+				shard.open(preamble, key.ID())
+				shardsStarted++
+				// the preamble already ends in the indentation before a page
+				text = bytes.TrimLeft(text, " \t\r\n")
+			}
+			shard.write(text)
+			shard.lastID = key.ID()
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if shard.f != nil {
+		shard.close(trailer)
+	}
+	r.Close()
+}
@@ -0,0 +1,102 @@
+// Public domain, dltp contributors, 2026
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSplit splits dump (written as enwiki-pages.xml) in a temp dir, and
+// returns the shards' names and contents
+func testSplit(t *testing.T, dump string, count int, size string) (names []string, shards []string) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "enwiki-pages.xml")
+	if err := os.WriteFile(fn, []byte(dump), 0666); err != nil {
+		t.Fatal(err)
+	}
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout, err = os.Open(os.DevNull) // Split lists the shards there
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCount, oldSize, oldCompression := *splitCount, *splitSize, *compression
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+		os.Chdir(oldDir)
+		*splitCount, *splitSize, *compression = oldCount, oldSize, oldCompression
+	}()
+	*splitCount, *splitSize, *compression = count, size, "auto"
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	Split(fn)
+
+	names, err = filepath.Glob("enwiki-pages-p*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, string(content))
+	}
+	return
+}
+
+func TestSplit(t *testing.T) {
+	pages := []string{
+		testPage("1", "a", "2001"),
+		testPage("2", "a", "2001"),
+		testPage("3", strings.Repeat("a", 500), "2001"), // bigger than a shard
+		testPage("10", "a", "2001"),
+		testPage("11", "a", "2001"),
+	}
+	names, shards := testSplit(t, testDump(pages...), 0, "350")
+	wantNames := []string{ // as Glob sorts them
+		"enwiki-pages-p10p11.xml",
+		"enwiki-pages-p1p2.xml",
+		"enwiki-pages-p3p3.xml",
+	}
+	wantShards := []string{
+		testDump(pages[3:5]...),
+		testDump(pages[0:2]...),
+		testDump(pages[2]),
+	}
+	if strings.Join(names, " ") != strings.Join(wantNames, " ") {
+		t.Fatalf("got shards %v, want %v", names, wantNames)
+	}
+	for i := range shards {
+		if shards[i] != wantShards[i] {
+			t.Fatalf("%s: got:\n%s\nwant:\n%s", names[i], shards[i], wantShards[i])
+		}
+	}
+
+	// -split N: whatever the boundaries, N complete dumps with every page once
+	names, shards = testSplit(t, testDump(pages...), 3, "")
+	if len(shards) != 3 {
+		t.Fatalf("-split 3: got shards %v", names)
+	}
+	all := ""
+	for _, shard := range shards {
+		if !strings.HasPrefix(shard, "<mediawiki>\n  <page>") || !strings.HasSuffix(shard, "</page>\n</mediawiki>\n") {
+			t.Fatalf("-split 3: incomplete shard:\n%s", shard)
+		}
+		all += strings.TrimSuffix(strings.TrimPrefix(shard, "<mediawiki>\n"), "</mediawiki>\n")
+	}
+	for _, page := range pages {
+		if strings.Count(all, page) != 1 {
+			t.Fatalf("-split 3: page isn't in exactly one shard:\n%s", page)
+		}
+	}
+}
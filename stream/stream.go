@@ -3,9 +3,9 @@
 package stream
 
 import (
-	"fmt" // likewise
+	"fmt"
 	"io"
-	"os" // for error handling
+	"os" // for file names in errors
 )
 
 /*
@@ -15,6 +15,11 @@ STREAM READAT HELPER
 take a stream and give it a file-like ReadAt method; we need this to stream
 from compressed source files
 
+reads are supposed to move forward through the stream, but we remember the
+last Rewind bytes ReadAt gave out, so a read can back up that far (two
+references overlapping, or a page that moved a bit earlier in the source) and
+be served from memory. backing up further gets you an error.
+
 */
 
 type Stream interface {
@@ -23,37 +28,115 @@ type Stream interface {
 	io.Closer
 }
 
+// how far back ReadAt can go, unless you set Rewind yourself
+var DefaultRewind = 16 << 20
+
 type StreamReaderAt struct {
 	r io.Reader
 	o int64
+	// how many bytes back from the current position ReadAt can reread
+	Rewind   int
+	ring     []byte // stream offset x is at ring[x%len(ring)], allocated lazily
+	keptFrom int64  // start of what's in ring
+}
+
+// RewindError is what you get from ReadAt'ing further back than Rewind
+// lets us.
+type RewindError struct {
+	Name     string // "" if we don't know it
+	Off      int64  // where the read wanted to start
+	Pos      int64  // where we were in the stream
+	KeptFrom int64  // the earliest offset we still had
+}
+
+func (e *RewindError) Error() string {
+	name := ""
+	if e.Name != "" {
+		name = " in " + e.Name
+	}
+	return fmt.Sprintf(
+		"tried to go back from %d to %d in stream%s, but only have from %d (a bigger -rewind may help)",
+		e.Pos, e.Off, name, e.KeptFrom,
+	)
 }
 
 func NewReaderAt(r io.Reader) *StreamReaderAt {
 	if r == nil {
 		panic("no file in NewReaderAt")
 	}
-	return &StreamReaderAt{r, 0} // fancy.
+	return &StreamReaderAt{r: r, Rewind: DefaultRewind}
 }
 
+// Read reads on from where the last read stopped. It doesn't keep what it
+// reads for ReadAt (something reading a whole stream this way would pay to
+// copy all of it into the ring), so ReadAt can't go back before it.
 func (sra *StreamReaderAt) Read(p []byte) (n int, err error) {
+	n, err = sra.read(p)
+	if n > 0 {
+		sra.keptFrom = sra.o
+	}
+	return
+}
+
+func (sra *StreamReaderAt) read(p []byte) (n int, err error) {
 	n, err = sra.r.Read(p)
 	sra.o += int64(n)
 	return
 }
 
+// keep remembers the n bytes just read into p
+func (sra *StreamReaderAt) keep(p []byte) {
+	if sra.ring == nil {
+		if sra.Rewind <= 0 {
+			sra.keptFrom = sra.o
+			return
+		}
+		sra.ring = make([]byte, sra.Rewind)
+		sra.keptFrom = sra.o - int64(len(p))
+	}
+	size := int64(len(sra.ring))
+	if int64(len(p)) > size {
+		p = p[int64(len(p))-size:]
+	}
+	start := sra.o - int64(len(p))
+	i := int(start % size)
+	copied := copy(sra.ring[i:], p)
+	copy(sra.ring, p[copied:])
+	if sra.o-sra.keptFrom > size {
+		sra.keptFrom = sra.o - size
+	}
+}
+
+// reread copies from the ring as much of [off, sra.o) as fits in p
+func (sra *StreamReaderAt) reread(p []byte, off int64) int {
+	if int64(len(p)) > sra.o-off {
+		p = p[:sra.o-off]
+	}
+	size := int64(len(sra.ring))
+	i := int(off % size)
+	n := copy(p, sra.ring[i:])
+	n += copy(p[n:], sra.ring)
+	return n
+}
+
 var discardBuf []byte
 
 const streamReaderAtDiscardChunk = 1e6
 
 func (sra *StreamReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
-	bytesToSkip := off - sra.o
-	if bytesToSkip < 0 {
-		f, ok := sra.r.(*os.File)
-		if ok {
-			fmt.Fprintln(os.Stderr, "file:", f.Name())
+	if off < sra.o {
+		if off < sra.keptFrom || sra.ring == nil {
+			name := ""
+			if f, ok := sra.r.(*os.File); ok {
+				name = f.Name()
+			}
+			return 0, &RewindError{name, off, sra.o, sra.keptFrom}
 		}
-		panic(fmt.Sprint("tried to skip from ", sra.o, " to ", off, " in stream"))
+		n = sra.reread(p, off)
+		p = p[n:]
+		off += int64(n)
 	}
+	bytesToSkip := off - sra.o
 	// would this inefficiently spin if waiting on pipe input?
 	// (not actually doing OS pipes here, but curious)
 	for bytesToSkip > 0 {
@@ -64,19 +147,20 @@ func (sra *StreamReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 		if bytesToSkip < int64(len(discardBuf)) {
 			discardInto = discardBuf[:bytesToSkip]
 		}
-		n, err := sra.Read(discardInto)
+		nSkipped, err := sra.read(discardInto)
+		sra.keep(discardInto[:nSkipped])
+		if err == io.EOF {
+			return n, err
+		}
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "OS error while discarding input from stream")
-			panic(err)
+			return n, fmt.Errorf("error while discarding input from stream: %s", err)
 		}
-		bytesToSkip -= int64(n)
+		bytesToSkip -= int64(nSkipped)
 	}
-	err = error(nil)
-	n = 0
-	toRead := len(p)
-	for n < toRead && err == nil {
+	for len(p) > 0 && err == nil {
 		nThisRead := 0
-		nThisRead, err = sra.Read(p)
+		nThisRead, err = sra.read(p)
+		sra.keep(p[:nThisRead])
 		p = p[nThisRead:]
 		n += nThisRead
 	}
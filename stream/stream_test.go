@@ -0,0 +1,88 @@
+// Public domain, dltp contributors, 2026
+
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+// readAt checks a ReadAt of n bytes at off gets the right ones
+func readAt(t *testing.T, sra *StreamReaderAt, data []byte, off int64, n int) {
+	t.Helper()
+	p := make([]byte, n)
+	got, err := sra.ReadAt(p, off)
+	if err != nil || got != n || !bytes.Equal(p, data[off:off+int64(n)]) {
+		t.Fatalf("ReadAt(%d bytes, %d): got %d bytes, %v, match: %v",
+			n, off, got, err, bytes.Equal(p[:got], data[off:off+int64(got)]))
+	}
+}
+
+// rewindError checks a ReadAt at off fails, having kept from keptFrom
+func rewindError(t *testing.T, sra *StreamReaderAt, off int64, keptFrom int64) {
+	t.Helper()
+	n, err := sra.ReadAt(make([]byte, 5), off)
+	rewindErr, ok := err.(*RewindError)
+	if n != 0 || !ok || rewindErr.Off != off || rewindErr.KeptFrom != keptFrom {
+		t.Fatalf("ReadAt(5 bytes, %d): got %d bytes, %v; want a RewindError keeping from %d", off, n, err, keptFrom)
+	}
+}
+
+func TestRewind(t *testing.T) {
+	data := testData(1000)
+	// short reads, so the ring gets filled a little at a time
+	sra := NewReaderAt(iotest.HalfReader(bytes.NewReader(data)))
+	sra.Rewind = 100
+
+	readAt(t, sra, data, 0, 30)
+	readAt(t, sra, data, 10, 30)   // back up, and read on past where we were
+	readAt(t, sra, data, 50, 120)  // skip ahead, with more than fits in the ring
+	readAt(t, sra, data, 70, 100)  // exactly as far back as we can go
+	rewindError(t, sra, 69, 70)    // one byte further
+	readAt(t, sra, data, 300, 10)  // skipping keeps what we skip too
+	readAt(t, sra, data, 210, 100) // rereading across the ring's wraparound
+	for off := int64(310); off < 990; off += 37 {
+		readAt(t, sra, data, off-20, 30)
+	}
+	rewindError(t, sra, 0, sra.o-100)
+
+	// at the end, we get what's left with EOF
+	p := make([]byte, 50)
+	n, err := sra.ReadAt(p, 980)
+	if n != 20 || err != io.EOF || !bytes.Equal(p[:n], data[980:]) {
+		t.Fatalf("ReadAt past the end: got %d bytes, %v", n, err)
+	}
+}
+
+func TestReadForgets(t *testing.T) {
+	data := testData(100)
+	sra := NewReaderAt(bytes.NewReader(data))
+	readAt(t, sra, data, 0, 20)
+	// what Read returns isn't kept, so backing up past it has to fail rather
+	// than give stale bytes
+	p := make([]byte, 10)
+	if n, err := sra.Read(p); n != 10 || err != nil || !bytes.Equal(p, data[20:30]) {
+		t.Fatalf("Read: got %d bytes, %v", n, err)
+	}
+	rewindError(t, sra, 15, 30)
+	rewindError(t, sra, 25, 30)
+	readAt(t, sra, data, 30, 10)
+	readAt(t, sra, data, 35, 5)
+
+	// with no Rewind, there's no going back at all
+	sra = NewReaderAt(bytes.NewReader(data))
+	sra.Rewind = 0
+	readAt(t, sra, data, 0, 20)
+	rewindError(t, sra, 19, 20)
+	readAt(t, sra, data, 20, 5)
+}
@@ -0,0 +1,320 @@
+// Public domain, dltp contributors, 2026
+
+package xzblocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+/*
+
+RANDOM ACCESS TO .XZ FILES
+
+An .xz file is one or more streams, each a series of independently compressed
+blocks followed by an index listing every block's sizes. (xz -T and our own
+writer make many blocks; plain single-threaded xz makes one.) Reading the
+indexes from the end of the file tells us where every block starts, so ReadAt
+only has to decompress the block(s) it's reading from.
+
+We only handle blocks that are plain LZMA2, which is all xz uses unless you ask
+for a BCJ or delta filter; for anything else (or a file that's all one block)
+NewReader returns ErrNotIndexable and you can read it straight through with
+github.com/ulikunitz/xz instead.
+
+The format's at https://tukaani.org/xz/xz-file-format.txt. We don't verify
+blocks' checks (CRC32/CRC64/SHA-256) when reading this way.
+
+*/
+
+var ErrNotIndexable = errors.New("xz file can't be read in blocks")
+
+var headerMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0}
+var footerMagic = []byte{'Y', 'Z'}
+
+const lzma2FilterID = 0x21
+
+type block struct {
+	offs, size     int64 // uncompressed
+	compressedOffs int64 // start of the block header
+	unpaddedSize   int64
+}
+
+type Reader struct {
+	ra     io.ReaderAt
+	blocks []block
+	size   int64
+
+	mu         sync.Mutex
+	cached     int // which block is in buf, or -1
+	buf        []byte
+	compressed []byte
+
+	readOffs int64
+}
+
+// size of the check at the end of each block, by check type
+func checkSize(flags byte) int64 {
+	checkType := flags & 0x0F
+	switch {
+	case checkType == 0:
+		return 0
+	case checkType <= 3:
+		return 4
+	case checkType <= 6:
+		return 8
+	case checkType <= 9:
+		return 16
+	case checkType <= 12:
+		return 32
+	}
+	return 64
+}
+
+func round4(n int64) int64 {
+	return (n + 3) &^ 3
+}
+
+// NewReader reads the indexes of ra, which is size bytes long.
+func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
+	r := &Reader{ra: ra, cached: -1}
+	var streams [][]block
+	end := size
+	for end > 0 {
+		// stream padding: zeros, four at a time
+		var word [4]byte
+		if _, err := ra.ReadAt(word[:], end-4); err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(word[:]) == 0 {
+			end -= 4
+			continue
+		}
+		blocks, start, err := readStream(ra, end)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, blocks)
+		end = start
+	}
+	for i := len(streams) - 1; i >= 0; i-- {
+		for _, b := range streams[i] {
+			b.offs = r.size
+			r.blocks = append(r.blocks, b)
+			r.size += b.size
+		}
+	}
+	if len(r.blocks) < 2 {
+		return nil, ErrNotIndexable
+	}
+	// make sure we can decode every block before saying we can
+	header := make([]byte, 1024) // the most a block header can be
+	for _, b := range r.blocks {
+		n, err := ra.ReadAt(header, b.compressedOffs)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if _, _, err = parseBlockHeader(header[:n]); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// readStream reads the footer and index of the stream ending at end, and
+// returns its blocks and where it starts.
+func readStream(ra io.ReaderAt, end int64) (blocks []block, start int64, err error) {
+	if end < 24 {
+		return nil, 0, ErrNotIndexable
+	}
+	footer := make([]byte, 12)
+	if _, err = ra.ReadAt(footer, end-12); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(footer[10:], footerMagic) {
+		return nil, 0, ErrNotIndexable
+	}
+	flags := footer[9]
+	indexSize := (int64(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	indexStart := end - 12 - indexSize
+	if indexStart < 12 {
+		return nil, 0, ErrNotIndexable
+	}
+	index := make([]byte, indexSize)
+	if _, err = ra.ReadAt(index, indexStart); err != nil {
+		return nil, 0, err
+	}
+	if index[0] != 0 {
+		return nil, 0, ErrNotIndexable
+	}
+	pos := 1
+	count, n := binary.Uvarint(index[pos:])
+	if n <= 0 {
+		return nil, 0, ErrNotIndexable
+	}
+	pos += n
+	compressed := int64(0)
+	for i := uint64(0); i < count; i++ {
+		unpadded, n := binary.Uvarint(index[pos:])
+		if n <= 0 {
+			return nil, 0, ErrNotIndexable
+		}
+		pos += n
+		uncompressed, n := binary.Uvarint(index[pos:])
+		if n <= 0 {
+			return nil, 0, ErrNotIndexable
+		}
+		pos += n
+		blocks = append(blocks, block{
+			size:           int64(uncompressed),
+			compressedOffs: compressed, // from the start of the stream for now
+			unpaddedSize:   int64(unpadded),
+		})
+		compressed += round4(int64(unpadded)-checkSize(flags)) + checkSize(flags)
+	}
+	start = indexStart - compressed - 12
+	if start < 0 {
+		return nil, 0, ErrNotIndexable
+	}
+	header := make([]byte, 12)
+	if _, err = ra.ReadAt(header, start); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(header[:6], headerMagic) || header[7] != flags {
+		return nil, 0, ErrNotIndexable
+	}
+	for i := range blocks {
+		blocks[i].compressedOffs += start + 12
+	}
+	return blocks, start, nil
+}
+
+// Size is how big the uncompressed content is.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// load decompresses block i into buf; call with mu held
+func (r *Reader) load(i int) error {
+	if r.cached == i {
+		return nil
+	}
+	b := r.blocks[i]
+	if int64(cap(r.compressed)) < b.unpaddedSize {
+		r.compressed = make([]byte, b.unpaddedSize)
+	}
+	in := r.compressed[:b.unpaddedSize]
+	if _, err := r.ra.ReadAt(in, b.compressedOffs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dictCap, dataStart, err := parseBlockHeader(in)
+	if err != nil {
+		return err
+	}
+	lr, err := lzma.Reader2Config{DictCap: dictCap}.NewReader2(bytes.NewReader(in[dataStart:]))
+	if err != nil {
+		return err
+	}
+	r.cached = -1
+	if int64(cap(r.buf)) < b.size {
+		r.buf = make([]byte, b.size)
+	}
+	r.buf = r.buf[:b.size]
+	if _, err = io.ReadFull(lr, r.buf); err != nil {
+		return err
+	}
+	r.cached = i
+	return nil
+}
+
+// parseBlockHeader gets the LZMA2 dictionary size out of a block header, and
+// where the compressed data starts
+func parseBlockHeader(in []byte) (dictCap int, dataStart int, err error) {
+	if len(in) == 0 {
+		return 0, 0, errors.New("bad xz block header")
+	}
+	headerSize := (int(in[0]) + 1) * 4
+	if in[0] == 0 || headerSize > len(in) {
+		return 0, 0, errors.New("bad xz block header")
+	}
+	header := in[:headerSize]
+	flags := header[1]
+	if flags&3 != 0 { // more than one filter
+		return 0, 0, ErrNotIndexable
+	}
+	pos := 2
+	for _, present := range []bool{flags&0x40 != 0, flags&0x80 != 0} {
+		if present { // compressed or uncompressed size; we have those
+			_, n := binary.Uvarint(header[pos:])
+			if n <= 0 {
+				return 0, 0, errors.New("bad xz block header")
+			}
+			pos += n
+		}
+	}
+	id, n := binary.Uvarint(header[pos:])
+	if n <= 0 || id != lzma2FilterID || pos+n+2 > len(header) {
+		return 0, 0, ErrNotIndexable
+	}
+	pos += n
+	if header[pos] != 1 { // LZMA2 has one byte of properties
+		return 0, 0, errors.New("bad xz block header")
+	}
+	prop := header[pos+1]
+	if prop >= 40 { // 4GB dictionaries (40) are legal, but we'll pass
+		return 0, 0, ErrNotIndexable
+	}
+	dictCap = (2 | int(prop&1)) << (prop/2 + 11)
+	if dictCap < lzma.MinDictCap {
+		dictCap = lzma.MinDictCap
+	}
+	return dictCap, headerSize, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := sort.Search(len(r.blocks), func(i int) bool {
+		return r.blocks[i].offs+r.blocks[i].size > off
+	})
+	for len(p) > 0 && i < len(r.blocks) {
+		if err = r.load(i); err != nil {
+			return n, err
+		}
+		c := copy(p, r.buf[off-r.blocks[i].offs:])
+		p = p[c:]
+		n += c
+		off += int64(c)
+		i++
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadAt(p, r.readOffs)
+	r.readOffs += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// Close closes the underlying ReaderAt, if it's also a Closer.
+func (r *Reader) Close() error {
+	if c, ok := r.ra.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
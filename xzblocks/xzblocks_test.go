@@ -0,0 +1,71 @@
+// Public domain, dltp contributors, 2026
+
+package xzblocks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func compress(t *testing.T, text []byte, blockSize int64) []byte {
+	out := &bytes.Buffer{}
+	w, err := xz.WriterConfig{BlockSize: blockSize}.NewWriter(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(text)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestReadAt(t *testing.T) {
+	text := &bytes.Buffer{}
+	for i := 0; text.Len() < 50000; i++ {
+		fmt.Fprintf(text, "<page><id>%d</id><text>%d</text></page>\n", i, i*i)
+	}
+	want := text.Bytes()
+
+	// two streams (like cat a.xz b.xz), with stream padding between
+	half := len(want) / 2
+	file := compress(t, want[:half], 1000)
+	file = append(file, 0, 0, 0, 0)
+	file = append(file, compress(t, want[half:], 3000)...)
+
+	ra := bytes.NewReader(file)
+	r, err := NewReader(ra, ra.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(want)) {
+		t.Fatalf("got size %d, want %d", r.Size(), len(want))
+	}
+	rng := rand.New(rand.NewSource(1)) // the same reads every run
+	for i := 0; i < 200; i++ {
+		off := rng.Intn(len(want))
+		p := make([]byte, rng.Intn(3000))
+		n, err := r.ReadAt(p, int64(off))
+		wantN := len(want) - off
+		if wantN > len(p) {
+			wantN = len(p)
+		}
+		if n != wantN || !bytes.Equal(p[:n], want[off:off+n]) {
+			t.Fatalf("ReadAt(%d bytes, %d): got %d bytes (%v), want %d", len(p), off, n, err, wantN)
+		}
+		if (err == io.EOF) != (n < len(p)) {
+			t.Fatalf("ReadAt(%d bytes, %d): err %v with %d bytes", len(p), off, err, n)
+		}
+	}
+
+	oneBlock := compress(t, want, 0)
+	ra = bytes.NewReader(oneBlock)
+	if _, err = NewReader(ra, ra.Size()); err != ErrNotIndexable {
+		t.Fatalf("one block: got %v, want ErrNotIndexable", err)
+	}
+}
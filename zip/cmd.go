@@ -0,0 +1,151 @@
+// Public domain, dltp contributors, 2026
+
+package zip
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+
+COMPRESSOR PROGRAMS
+
+When we pipe through bzip2, xz, etc., the program failing has to come back
+to us as an error, or a corrupt .bz2 reference just looks like one that ends
+early, and all you learn is that the checksum didn't match. So we wait for
+the program when its output ends (or we close its input), and if it exited
+unhappily, return a CmdError naming the command and the file, with the end
+of what it printed on stderr. What it prints still goes to our stderr too, as
+it prints it, so warnings from a program that then succeeds aren't lost.
+
+*/
+
+// CmdError is a compressor or decompressor program failing.
+type CmdError struct {
+	Cmd    string // e.g. "bzip2 -dc"
+	Name   string // the file it was working on, if we know
+	Err    error  // usually an *exec.ExitError
+	Stderr string // the end of what it said
+}
+
+func (e *CmdError) Error() string {
+	msg := e.Cmd
+	if e.Name != "" {
+		msg += " on " + e.Name
+	}
+	msg += " failed: " + e.Err.Error()
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// how much of a program's stderr to keep
+const stderrKeep = 4096
+
+type stderrTail struct {
+	buf []byte
+}
+
+func (t *stderrTail) Write(p []byte) (n int, err error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrKeep {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-stderrKeep:]...)
+	}
+	return len(p), nil
+}
+
+// nameOf is the filename behind r or w, if it has one (like an *os.File)
+func nameOf(f interface{}) string {
+	if named, ok := f.(interface {
+		Name() string
+	}); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+// startCmd sets cmd up to pass its stderr on and keep the end of it, starts
+// it, and returns a function that waits for it and says how it failed, if it
+// did
+func startCmd(cmd *exec.Cmd, name string) (wait func() error, err error) {
+	stderr := &stderrTail{}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	cmdLine := strings.Join(append([]string{filepath.Base(cmd.Path)}, cmd.Args[1:]...), " ")
+	fail := func(err error) error {
+		return &CmdError{cmdLine, name, err, strings.TrimSpace(string(stderr.buf))}
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fail(err)
+	}
+	waited, waitErr := false, error(nil)
+	wait = func() error {
+		if !waited {
+			waited = true
+			if err := cmd.Wait(); err != nil {
+				waitErr = fail(err)
+			}
+		}
+		return waitErr
+	}
+	return wait, nil
+}
+
+// CmdPipe is the input of a compressor program. Close waits for it to finish.
+type CmdPipe struct {
+	writer io.WriteCloser
+	wait   func() error
+}
+
+func (c *CmdPipe) Write(p []byte) (n int, err error) {
+	n, err = c.writer.Write(p)
+	if err != nil { // probably a broken pipe; say why it broke
+		if waitErr := c.wait(); waitErr != nil {
+			err = waitErr
+		}
+	}
+	return
+}
+
+func (c *CmdPipe) Close() error {
+	err := c.writer.Close()
+	if waitErr := c.wait(); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
+
+// CmdReader is the output of a decompressor program. When it ends, we check
+// the program exited OK. Close reads whatever's left, so the program doesn't
+// die of a useless "broken pipe," and returns any error.
+type CmdReader struct {
+	out  io.Reader
+	wait func() error
+	err  error // once the output's ended
+}
+
+func (cr *CmdReader) Read(p []byte) (n int, err error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	n, err = cr.out.Read(p)
+	if err != nil {
+		if waitErr := cr.wait(); waitErr != nil {
+			err = waitErr
+		}
+		cr.err = err
+	}
+	return
+}
+
+func (cr *CmdReader) Close() error {
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return err
+	}
+	return nil
+}
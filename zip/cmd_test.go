@@ -0,0 +1,112 @@
+// Public domain, dltp contributors, 2026
+
+package zip
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubLzop puts an lzop on PATH that prints some output, then script
+func stubLzop(t *testing.T, script string) {
+	dir := t.TempDir()
+	stub := "#!/bin/sh\necho some output\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "lzop"), []byte(stub), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// captureStderr runs f with os.Stderr going to a file, and returns what f
+// (and anything it ran) wrote there
+func captureStderr(t *testing.T, f func()) string {
+	fn := filepath.Join(t.TempDir(), "stderr")
+	capture, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stderr
+	os.Stderr = capture
+	defer func() { os.Stderr = old }()
+	f()
+	capture.Close()
+	printed, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(printed)
+}
+
+func checkCmdError(t *testing.T, err error, cmd string, name string, stderr string) {
+	t.Helper()
+	cmdErr, ok := err.(*CmdError)
+	if !ok {
+		t.Fatalf("got %v, want a CmdError", err)
+	}
+	if cmdErr.Cmd != cmd || cmdErr.Name != name || cmdErr.Stderr != stderr {
+		t.Fatalf("got command %q on %q saying %q, want %q on %q saying %q",
+			cmdErr.Cmd, cmdErr.Name, cmdErr.Stderr, cmd, name, stderr)
+	}
+	if !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("message doesn't say how it failed: %s", err)
+	}
+}
+
+func TestCmdReader(t *testing.T) {
+	stubLzop(t, "echo 'lzop: bad block' >&2; exit 1")
+	in := filepath.Join(t.TempDir(), "ref.xml.lzo")
+	if err := os.WriteFile(in, []byte("not really lzo"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var content []byte
+	printed := captureStderr(t, func() {
+		r, err := NewReader(f, "lzo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err = io.ReadAll(r)
+		checkCmdError(t, err, "lzop -dc", in, "lzop: bad block")
+		// and it stays failed
+		if _, err = r.Read(make([]byte, 10)); err == nil || err == io.EOF {
+			t.Fatalf("Read after failing: got %v", err)
+		}
+	})
+	if string(content) != "some output\n" {
+		t.Fatalf("got output %q", content)
+	}
+	if printed != "lzop: bad block\n" {
+		t.Fatalf("got %q on our stderr", printed)
+	}
+}
+
+func TestCmdPipe(t *testing.T) {
+	// lots on stderr, of which we keep the end
+	stubLzop(t, "cat > /dev/null; head -c 10000 /dev/zero | tr '\\0' x >&2; echo ' lzop: disk full' >&2; exit 1")
+	out, err := os.Create(filepath.Join(t.TempDir(), "pack.dltp.lzo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	printed := captureStderr(t, func() {
+		w := NewWriter(out, "lzo:7")
+		if _, err := w.Write(bytes.Repeat([]byte("x"), 100000)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		tail := strings.Repeat("x", stderrKeep-len(" lzop: disk full\n")) + " lzop: disk full"
+		checkCmdError(t, w.Close(), "lzop -c -7", out.Name(), tail)
+	})
+	if len(printed) != 10000+len(" lzop: disk full\n") {
+		t.Fatalf("got %d bytes on our stderr", len(printed))
+	}
+}
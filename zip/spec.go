@@ -0,0 +1,239 @@
+// Public domain, dltp contributors, 2026
+
+package zip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/twotwotwo/dltp/zstdseek"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+/*
+
+COMPRESSION SPECS
+
+-zip takes a format, optionally followed by a colon and comma-separated
+options: a level ("bz2:9", "gz:1"), with "e" after it for xz's extreme mode
+("xz:9e"), and "long" for zstd ("zst:19,long"). NewWriter takes the same specs
+and passes the options along to the compressor program, or the closest thing
+for the built-in compressors.
+
+zstd's "long" means bigger seekable frames (FrameSizeLong) and a window as big,
+so zstd can find repeats further apart, like the zstd program's --long. It
+makes ReadAt slower, since it has to decompress a whole frame.
+
+We always write .zst in the seekable format, with the built-in encoder. It only
+has four speeds, and zstd.EncoderLevelFromZstd maps levels onto them: 1-2 are
+its fastest (about zstd -1), 3-5 its default (about -3), 6-9 "better" (about
+-7), and 10-22 its best (about -11). Levels past 11 are for squeezing out the
+last few percent, so if the zstd program is installed we run it on each frame
+for those instead (with --ultra past 19). Starting a process per frame isn't
+free, and at 11 and below the built-in encoder was as fast or faster, so we
+don't use the program there. Note says when a level is getting rounded.
+
+*/
+
+type Spec struct {
+	Format  string
+	Level   int  // -1 for the compressor's default
+	Extreme bool // xz only
+	Long    bool // zst only
+}
+
+// how high levels go; they start at 1, except xz's at 0
+var maxLevels = map[string]int{
+	"gz":  9,
+	"bz2": 9,
+	"lzo": 9,
+	"xz":  9,
+	"zst": 22,
+}
+
+// ParseSpec reads a spec like "zst:19,long", checking it makes sense.
+func ParseSpec(spec string) (s Spec, err error) {
+	format, options := spec, ""
+	if i := strings.IndexByte(spec, ':'); i > -1 {
+		format, options = spec[:i], spec[i+1:]
+	}
+	s.Format = CanonicalFormatName(format)
+	s.Level = -1
+	if !IsKnown(s.Format) {
+		return s, fmt.Errorf("unknown compression type '%s'", format)
+	}
+	if options == "" {
+		return s, nil
+	}
+	for _, opt := range strings.Split(options, ",") {
+		switch {
+		case opt == "long" && s.Format == "zst":
+			s.Long = true
+		case opt != "" && opt[0] >= '0' && opt[0] <= '9':
+			level := opt
+			if s.Format == "xz" && strings.HasSuffix(level, "e") {
+				s.Extreme = true
+				level = level[:len(level)-1]
+			}
+			minLevel := 1
+			if s.Format == "xz" {
+				minLevel = 0
+			}
+			s.Level, err = strconv.Atoi(level)
+			if err != nil || s.Level < minLevel || s.Level > maxLevels[s.Format] {
+				return s, fmt.Errorf("bad level '%s' for %s (%d to %d)", opt, s.Format, minLevel, maxLevels[s.Format])
+			}
+		default:
+			return s, fmt.Errorf("unknown option '%s' for %s", opt, s.Format)
+		}
+	}
+	return s, nil
+}
+
+// FormatOf is the format part of a spec, e.g. for a file extension.
+func FormatOf(spec string) string {
+	if i := strings.IndexByte(spec, ':'); i > -1 {
+		spec = spec[:i]
+	}
+	return CanonicalFormatName(spec)
+}
+
+func (s Spec) String() string {
+	options := []string{}
+	if s.Level >= 0 {
+		level := strconv.Itoa(s.Level)
+		if s.Extreme {
+			level += "e"
+		}
+		options = append(options, level)
+	}
+	if s.Long {
+		options = append(options, "long")
+	}
+	if len(options) == 0 {
+		return s.Format
+	}
+	return s.Format + ":" + strings.Join(options, ",")
+}
+
+// args are what to pass a compressor program
+func (s Spec) args() []string {
+	if s.Level < 0 {
+		return nil
+	}
+	level := "-" + strconv.Itoa(s.Level)
+	if s.Extreme {
+		level += "e"
+	}
+	return []string{level}
+}
+
+// for zst:long
+const FrameSizeLong = 128 << 20
+
+func newZstdWriter(out io.Writer, s Spec) io.WriteCloser {
+	frameSize := 0
+	if s.Long {
+		frameSize = FrameSizeLong
+	}
+	if cmdPath := findZipper("zst"); cmdPath != "" && s.Level > maxBuiltinZstdLevel {
+		return zstdseek.NewWriterFunc(out, frameSize, zstdCmdFrames(cmdPath, s, nameOf(out)))
+	}
+	opts := []zstd.EOption{}
+	if s.Level >= 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(s.Level)))
+	}
+	if s.Long {
+		opts = append(opts, zstd.WithWindowSize(FrameSizeLong))
+	}
+	w, err := zstdseek.NewWriter(out, frameSize, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// roughly what zstd level each of the built-in encoder's speeds is
+var builtinZstdLevels = map[zstd.EncoderLevel]int{
+	zstd.SpeedFastest:           1,
+	zstd.SpeedDefault:           3,
+	zstd.SpeedBetterCompression: 7,
+	zstd.SpeedBestCompression:   11,
+}
+
+// the built-in encoder's best; higher levels use the zstd program if we can
+const maxBuiltinZstdLevel = 11
+
+// Note says how the compressor we'll use falls short of s, or "" if it
+// doesn't.
+func (s Spec) Note() string {
+	if s.Format != "zst" || s.Level < 0 {
+		return ""
+	}
+	if s.Level > maxBuiltinZstdLevel && findZipper("zst") != "" {
+		return ""
+	}
+	speed := zstd.EncoderLevelFromZstd(s.Level)
+	if builtinZstdLevels[speed] == s.Level {
+		return ""
+	}
+	note := fmt.Sprintf(
+		"zst level %d gets the built-in encoder's %q speed, about level %d",
+		s.Level, speed, builtinZstdLevels[speed],
+	)
+	if s.Level > maxBuiltinZstdLevel {
+		note += " (install the zstd program for levels past 11)"
+	}
+	return note
+}
+
+// zstdCmdFrames compresses each seekable frame by running the zstd program
+// on it; name is the output file, for errors
+func zstdCmdFrames(cmdPath string, s Spec, name string) zstdseek.CompressFunc {
+	args := []string{"-q", "-c"}
+	if s.Level > 19 {
+		args = append(args, "--ultra")
+	}
+	args = append(args, s.args()...)
+	if s.Long {
+		args = append(args, "--long=27") // 1<<27 is FrameSizeLong
+	}
+	return func(in []byte) ([]byte, error) {
+		cmd := exec.Command(cmdPath, args...)
+		cmd.Stdin = bytes.NewReader(in)
+		out := bytes.NewBuffer(make([]byte, 0, len(in)/2))
+		cmd.Stdout = out
+		wait, err := startCmd(cmd, name)
+		if err == nil {
+			err = wait()
+		}
+		return out.Bytes(), err
+	}
+}
+
+// xz's presets' dictionary sizes, by level
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// newXZWriter is the built-in xz compressor: slow, but it writes in blocks, so
+// xzblocks can read it back quickly. extreme mode gets the slower, better
+// match finder.
+func newXZWriter(out io.Writer, s Spec) io.WriteCloser {
+	config := xz.WriterConfig{BlockSize: 16 << 20}
+	if s.Level >= 0 {
+		config.DictCap = xzDictCaps[s.Level]
+	}
+	if s.Extreme {
+		config.Matcher = lzma.BinaryTree
+	}
+	w, err := config.NewWriter(out)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
@@ -0,0 +1,151 @@
+// Public domain, dltp contributors, 2026
+
+package zip
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twotwotwo/dltp/zstdseek"
+)
+
+// writeZstd compresses want as spec, and checks it reads back as seekable
+// zstd
+func writeZstd(t *testing.T, spec string, want []byte) {
+	t.Helper()
+	compressed := &bytes.Buffer{}
+	w := NewWriter(compressed, spec)
+	w.Write(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	ra := bytes.NewReader(compressed.Bytes())
+	r, err := zstdseek.NewReader(ra, ra.Size())
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	got := make([]byte, len(want))
+	if n, _ := r.ReadAt(got, 0); n != len(want) || !bytes.Equal(got, want) {
+		t.Fatalf("%s: got %d bytes back, match: %v", spec, n, bytes.Equal(got, want))
+	}
+}
+
+func TestZstdWriter(t *testing.T) {
+	text := &bytes.Buffer{}
+	for i := 0; text.Len() < 5<<20; i++ { // more than one frame
+		fmt.Fprintf(text, "<page><id>%d</id><text>%d</text></page>\n", i, i*i)
+	}
+	want := text.Bytes()
+
+	// the zstd program, if there is one, for levels past the built-in's
+	if _, err := exec.LookPath("zstd"); err == nil {
+		writeZstd(t, "zst:12", want)
+		writeZstd(t, "zst:20", want[:100000]) // needs --ultra
+	}
+
+	// a zstd program that fails has to fail the write
+	dir := t.TempDir()
+	stub := "#!/bin/sh\necho 'zstd: out of cheese' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "zstd"), []byte(stub), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	w := NewWriter(&bytes.Buffer{}, "zst:19")
+	w.Write(want)
+	err := w.Close()
+	if cmdErr, ok := err.(*CmdError); !ok || !strings.Contains(cmdErr.Cmd, "-19") || cmdErr.Stderr != "zstd: out of cheese" {
+		t.Fatalf("failing zstd program: got %v", err)
+	}
+	// and levels the built-in encoder has don't run it at all
+	writeZstd(t, "zst:11", want[:100000])
+
+	// built in
+	t.Setenv("PATH", "")
+	writeZstd(t, "zst", want)
+	writeZstd(t, "zst:19,long", want[:100000])
+}
+
+func TestParseSpec(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want Spec
+		str  string // String(), if not spec
+	}{
+		{"bz2", Spec{"bz2", -1, false, false}, ""},
+		{"bz2:9", Spec{"bz2", 9, false, false}, ""},
+		{"gzip:1", Spec{"gz", 1, false, false}, "gz:1"},
+		{"xz:0", Spec{"xz", 0, false, false}, ""},
+		{"xz:9e", Spec{"xz", 9, true, false}, ""},
+		{"zst:19,long", Spec{"zst", 19, false, true}, ""},
+		{"zst:long,22", Spec{"zst", 22, false, true}, "zst:22,long"},
+		{"zstd:long", Spec{"zst", -1, false, true}, "zst:long"},
+	} {
+		got, err := ParseSpec(c.spec)
+		if err != nil || got != c.want {
+			t.Errorf("ParseSpec(%q): got %+v, %v, want %+v", c.spec, got, err, c.want)
+			continue
+		}
+		str := c.str
+		if str == "" {
+			str = c.spec
+		}
+		if got.String() != str {
+			t.Errorf("ParseSpec(%q).String(): got %q, want %q", c.spec, got.String(), str)
+		}
+		if again, err := ParseSpec(got.String()); err != nil || again != got {
+			t.Errorf("ParseSpec(%q) doesn't round-trip: got %+v, %v", got.String(), again, err)
+		}
+	}
+
+	for _, spec := range []string{
+		"rar",   // unknown format
+		"bz2:0", // levels too low
+		"zst:0",
+		"bz2:10", // and too high
+		"zst:23",
+		"xz:10e",
+		"gz:fast", // unknown options
+		"bz2:9e",  // e is only for xz
+		"gz:long", // long only for zstd
+		"zst:19,x",
+	} {
+		if got, err := ParseSpec(spec); err == nil {
+			t.Errorf("ParseSpec(%q): got %+v, want an error", spec, got)
+		}
+	}
+}
+
+func TestNote(t *testing.T) {
+	t.Setenv("PATH", "") // built-in zstd
+	for spec, rounded := range map[string]bool{
+		"zst": false, "zst:1": false, "zst:3": false, "zst:11": false,
+		"zst:2": true, "zst:19": true, "bz2:9": false,
+	} {
+		s, _ := ParseSpec(spec)
+		if (s.Note() != "") != rounded {
+			t.Errorf("%s: got note %q", spec, s.Note())
+		}
+	}
+	s, _ := ParseSpec("zst:19")
+	if !strings.Contains(s.Note(), `"best" speed, about level 11`) {
+		t.Errorf("zst:19: got note %q", s.Note())
+	}
+
+	// with the zstd program, only levels below 11 get rounded
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "zstd"), []byte("#!/bin/sh\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	for spec, rounded := range map[string]bool{"zst:2": true, "zst:11": false, "zst:19": false} {
+		s, _ := ParseSpec(spec)
+		if (s.Note() != "") != rounded {
+			t.Errorf("with zstd installed, %s: got note %q", spec, s.Note())
+		}
+	}
+}
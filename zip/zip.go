@@ -3,44 +3,86 @@
 package zip
 
 import (
+	"bufio"         // to peek at stdin's magic number
+	"bytes"         // and compare it
 	"compress/gzip" // fallback f/no pipeable gzip present (e.g., Windows)
+	"github.com/klauspost/compress/zstd"
 	bzip2 "github.com/twotwotwo/dltp/bz2blocks"
-	"github.com/twotwotwo/dltp/httpfile" // who doznt like it.
-	"github.com/twotwotwo/dltp/stream"   // allow skipping fwd through streams
+	"github.com/twotwotwo/dltp/httpfile"  // who doznt like it.
+	"github.com/twotwotwo/dltp/spillfile" // random access to compressed refs
+	"github.com/twotwotwo/dltp/stream"    // allow skipping fwd through streams
+	"github.com/twotwotwo/dltp/xzblocks"  // xz we can ReadAt
+	"github.com/twotwotwo/dltp/zstdseek"  // zstd we can ReadAt
+	"github.com/ulikunitz/xz"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings" // filename fun
 )
 
-// know what would be cool to support here? snappy
-// https://code.google.com/p/snappy-go/
-// same purpose lzo serves now ("free" compression to speed disk I/O)
-
 /*
 
 (UN)ZIP HELPER
 
 Open(path string):
 given path "a.xml", open a.xml, a.xml.gz, or a.xml.bz2, and either pipe via
-a native decompressor or use go's own. either way, return a Reader.
+a native decompressor or use go's own. either way, return a Reader. what the
+file starts with (its magic number) decides how it's decompressed, so a
+misnamed file still works.
+
+NewSniffingReader:
+the same for a stream like stdin: decompress it if it looks compressed.
+
+zstd is the fast option these days (it's what lzo was for: "free"
+compression to speed disk I/O). we always write it in the seekable format, so
+OpenRandomAccess can ReadAt it without decompressing everything before; the
+zstd program, if there is one, speeds up reading straight through, and
+compresses the frames for us at levels the built-in encoder doesn't reach.
+
+OpenRandomAccess(path string):
+like Open, but if SpillDir or CacheDir is set, compressed files are
+decompressed to disk as they're read, so ReadAt works anywhere in them.
 
 NewWriter/NewReader:
-pipe through a native compressor or use go's own gzip
+pipe through a native compressor or use go's own gzip, xz, or bzip2
+(bz2blocks compresses blocks in parallel, like lbzip2)
 
 */
 
-var suffixes = []string{"", ".lzo", ".gz", ".bz2", ".xz"}
+var suffixes = []string{"", ".lzo", ".gz", ".bz2", ".xz", ".zst"}
 var programs = map[string]string{
 	"lzo": "lzop",
 	"gz":  "pigz gzip",
 	"bz2": "lbzip2 bzip2",
 	"xz":  "xz",
+	"zst": "zstd",
 }
+
+// what compressed files start with
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{"bz2", []byte("BZh")},
+	{"gz", []byte{0x1f, 0x8b}},
+	{"xz", []byte{0xFD, '7', 'z', 'X', 'Z', 0}},
+	{"lzo", []byte{0x89, 'L', 'Z', 'O', 0, '\r', '\n', 0x1a, '\n'}},
+	{"zst", []byte{0x28, 0xB5, 0x2F, 0xFD}},
+}
+
+const sniffLen = 9 // longest magic
+
+// where OpenRandomAccess puts temp copies of compressed files ("" for off)
+var SpillDir = ""
+
+// where OpenRandomAccess keeps copies of compressed files to reuse later
+// ("" for off)
+var CacheDir = ""
+
 var canonicalFormatNames = map[string]string{
 	"bzip2": "bz2",
 	"gzip":  "gz",
+	"zstd":  "zst",
 }
 
 // Name without any known zip suffixes attached.
@@ -69,6 +111,14 @@ func IsKnown(compression string) bool {
 }
 
 func Open(path string, workingDir *os.File) (s stream.Stream, err error) {
+	return open(path, workingDir, false)
+}
+
+func OpenRandomAccess(path string, workingDir *os.File) (s stream.Stream, err error) {
+	return open(path, workingDir, true)
+}
+
+func open(path string, workingDir *os.File, randomAccess bool) (s stream.Stream, err error) {
 	reader := stream.Stream(nil)
 	fn := path
 
@@ -94,46 +144,118 @@ func Open(path string, workingDir *os.File) (s stream.Stream, err error) {
 		return nil, err
 	}
 
-	var compressedReader io.Reader
+	cacheName := ""
+	isLocal := !strings.HasPrefix(path, "http://")
+	format := ""
+	head := make([]byte, sniffLen)
+	n, err := reader.ReadAt(head, 0)
+	if err == nil || err == io.EOF {
+		format = Sniff(head[:n])
+	} else { // go by the name
+		for _, suffix := range suffixes[1:] {
+			if strings.HasSuffix(fn, suffix) {
+				format = suffix[1:]
+			}
+		}
+	}
 
-	for _, suffix := range suffixes {
-		if suffix == "" {
-			continue
+	if randomAccess && CacheDir != "" && isLocal && format != "" {
+		cacheName, err = spillfile.CacheName(fn, CacheDir)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		if cached, err := os.Open(cacheName); err == nil {
+			reader.Close()
+			return cached, nil
 		}
-		if !strings.HasSuffix(fn, suffix) {
-			continue
+	}
+
+	if randomAccess && isLocal && format == "zst" {
+		if sr, err := openSeekable(reader); err == nil {
+			return sr, nil
+		}
+	}
+	if randomAccess && isLocal && format == "xz" {
+		if br, err := openXZBlocks(reader); err == nil {
+			return br, nil
 		}
-		compressedReader, err = NewReader(reader, suffix[1:])
+	}
+
+	var compressedReader io.Reader
+
+	if format != "" {
+		compressedReader, err = newReader(reader, format, fn)
 		if err != nil {
+			reader.Close()
 			return nil, err
 		}
-		break
 	}
 
 	// return a Reader/ReaderAt, either file or wrapper
 	if compressedReader == nil {
 		return reader, nil
+	} else if cacheName != "" {
+		return spillfile.NewCached(compressedReader, cacheName)
+	} else if randomAccess && (SpillDir != "" || CacheDir != "") {
+		dir := SpillDir
+		if dir == "" {
+			dir = CacheDir // e.g., for a compressed file over HTTP
+		}
+		return spillfile.New(compressedReader, dir)
 	} else {
 		return stream.NewReaderAt(compressedReader), nil
 	}
 }
 
-type CmdPipe struct {
-	cmd    *exec.Cmd
-	writer io.WriteCloser
+// Sniff says what compression format a file starting with head is in, or ""
+// if it doesn't look compressed.
+func Sniff(head []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return ""
 }
 
-func (c *CmdPipe) Write(p []byte) (n int, err error) {
-	return c.writer.Write(p)
+// NewSniffingReader decompresses in if it starts like a compressed file, and
+// passes it through if not. It returns the format it found ("" for none).
+func NewSniffingReader(in io.Reader) (r io.Reader, format string, err error) {
+	br := bufio.NewReaderSize(in, 1<<16)
+	head, _ := br.Peek(sniffLen) // short if in is; Sniff copes
+	format = Sniff(head)
+	if format == "" {
+		return br, "", nil
+	}
+	r, err = newReader(br, format, nameOf(in))
+	return r, format, err
 }
 
-func (c *CmdPipe) Close() error {
-	err := c.writer.Close()
+// openSeekable gives a zstdseek.Reader for f if it's in the seekable format
+func openSeekable(f stream.Stream) (*zstdseek.Reader, error) {
+	file, ok := f.(*os.File)
+	if !ok {
+		return nil, zstdseek.ErrNotSeekable
+	}
+	info, err := file.Stat()
 	if err != nil {
-		c.cmd.Wait()
-		return err
+		return nil, err
 	}
-	return c.cmd.Wait()
+	return zstdseek.NewReader(file, info.Size())
+}
+
+// openXZBlocks gives an xzblocks.Reader for f if it has multiple blocks
+func openXZBlocks(f stream.Stream) (*xzblocks.Reader, error) {
+	file, ok := f.(*os.File)
+	if !ok {
+		return nil, xzblocks.ErrNotIndexable
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return xzblocks.NewReader(file, info.Size())
 }
 
 func findZipper(format string) (cmdPath string) {
@@ -158,32 +280,63 @@ func findZipper(format string) (cmdPath string) {
 }
 
 func CanWrite(format string) bool {
-	if format == "gz" {
+	if format == "gz" || format == "zst" || format == "xz" || format == "bz2" {
 		return true
 	}
 	return findZipper(format) != ""
 }
 
-func NewWriter(out io.Writer, format string) io.WriteCloser {
+// NewWriter compresses to out as spec (see ParseSpec) says.
+func NewWriter(out io.Writer, spec string) io.WriteCloser {
+	s, err := ParseSpec(spec)
+	if err != nil {
+		panic(err)
+	}
+	format := s.Format
+	if format == "zst" { // always seekable, maybe with the zstd program's help
+		return newZstdWriter(out, s)
+	}
 	cmdPath := findZipper(format)
 	if cmdPath == "" {
 		if format == "gz" {
-			return gzip.NewWriter(out)
+			level := gzip.DefaultCompression
+			if s.Level >= 0 {
+				level = s.Level
+			}
+			w, err := gzip.NewWriterLevel(out, level)
+			if err != nil {
+				panic(err)
+			}
+			return w
+		} else if format == "xz" {
+			return newXZWriter(out, s)
+		} else if format == "bz2" {
+			level := 9 // bzip2's default
+			if s.Level >= 0 {
+				level = s.Level
+			}
+			w, err := bzip2.NewWriter(out, level)
+			if err != nil {
+				panic(err)
+			}
+			return w
 		} else {
 			panic("cannot write format " + format)
 		}
 	}
-	cmd := exec.Command(cmdPath, "-c")
+	cmd := exec.Command(cmdPath, append([]string{"-c"}, s.args()...)...)
 	cmd.Stdout = out
-	cmd.Stderr = os.Stderr
 	writer, err := cmd.StdinPipe()
 	if err != nil {
 		panic(err)
 	}
-	cmd.Start()
+	wait, err := startCmd(cmd, nameOf(out))
+	if err != nil {
+		panic(err)
+	}
 	// not clear to me that writer.Close() would or could wait on zipper to
 	// exit. so wrap it to add waiting
-	return &CmdPipe{cmd, writer}
+	return &CmdPipe{writer, wait}
 }
 
 type UnsupportedFormat struct {
@@ -194,27 +347,24 @@ func (e UnsupportedFormat) Error() string {
 	return "unsupported format " + e.format
 }
 
-// act as an io.Reader but make Close() copy the rest of the content to a blackhole,
-// to avoid a "broken pipe" error from the process we're streaming from (we don't want
-// to hide all errors from the user, but this one's useless)
-type FinishingReader struct {
-	io.Reader
-}
-
-func NewFinishingReader(in io.Reader) (fr *FinishingReader) {
-	return &FinishingReader{in}
-}
-
-func (fr *FinishingReader) Close() error {
-	_, err := io.Copy(ioutil.Discard, fr.Reader)
-	return err
+func NewReader(in io.Reader, format string) (rc io.Reader, err error) {
+	return newReader(in, format, nameOf(in))
 }
 
-func NewReader(in io.Reader, format string) (rc io.Reader, err error) {
+// newReader is NewReader, knowing the filename for error messages
+func newReader(in io.Reader, format string, name string) (rc io.Reader, err error) {
 	cmdPath := findZipper(format)
 	if cmdPath == "" {
 		if format == "gz" {
 			return gzip.NewReader(in)
+		} else if format == "xz" {
+			return xz.NewReader(in)
+		} else if format == "zst" {
+			d, err := zstd.NewReader(in)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
 		} else if format == "bz2" {
 			if ra, ok := in.(io.ReaderAt); ok {
 				return bzip2.NewParallelReader(ra), nil
@@ -226,11 +376,13 @@ func NewReader(in io.Reader, format string) (rc io.Reader, err error) {
 	}
 	cmd := exec.Command(cmdPath, "-dc")
 	cmd.Stdin = in
-	cmd.Stderr = os.Stderr
 	reader, err := cmd.StdoutPipe()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	wait, err := startCmd(cmd, name)
+	if err != nil {
+		return nil, err
 	}
-	cmd.Start()
-	return NewFinishingReader(reader), nil
+	return &CmdReader{out: reader, wait: wait}, nil
 }
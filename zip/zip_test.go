@@ -0,0 +1,64 @@
+// Public domain, dltp contributors, 2026
+
+package zip
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	for head, want := range map[string]string{
+		"BZh91AY&SY":                       "bz2",
+		"\x1f\x8b\x08\x00":                 "gz",
+		"\xfd7zXZ\x00\x00\x04":             "xz",
+		"\x89LZO\x00\r\n\x1a\n\x10":        "lzo",
+		"\x28\xb5\x2f\xfd\x00":             "zst",
+		"\x28\xb5\x2f\xfd":                 "zst", // just the magic
+		"\xfd7zXZ":                         "",    // cut short
+		"\x89LZO\x00\r\n\x1a":              "",
+		"BZ":                               "",
+		"":                                 "",
+		"<mediawiki xmlns=\"http://www...": "",
+		"<?xml version=\"1.0\"?>":          "",
+		"  <page>":                         "",
+	} {
+		if got := Sniff([]byte(head)); got != want {
+			t.Errorf("Sniff(%q): got %q, want %q", head, got, want)
+		}
+	}
+}
+
+func TestSniffingReader(t *testing.T) {
+	t.Setenv("PATH", "") // the built-in compressors
+	want := []byte("<mediawiki>\n  <page><id>1</id></page>\n</mediawiki>\n")
+	for _, format := range []string{"gz", "bz2", "xz", "zst"} {
+		compressed := &bytes.Buffer{}
+		w := NewWriter(compressed, format)
+		w.Write(want)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, got, err := NewSniffingReader(compressed)
+		if err != nil || got != format {
+			t.Fatalf("%s: sniffed %q, %v", format, got, err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(content, want) {
+			t.Fatalf("%s: got %q, %v", format, content, err)
+		}
+	}
+
+	// plain XML, or anything too short to tell, passes through
+	for _, in := range [][]byte{want, []byte("<"), nil} {
+		r, format, err := NewSniffingReader(bytes.NewReader(in))
+		if err != nil || format != "" {
+			t.Fatalf("%q: sniffed %q, %v", in, format, err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(content, in) {
+			t.Fatalf("%q: got %q, %v", in, content, err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Public domain, dltp contributors, 2026
+
+package zstdseek
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+
+SEEKABLE ZSTD
+
+The seekable zstd format (from zstd's contrib/seekable_format) is a series of
+independent zstd frames followed by a seek table in a skippable frame, which
+plain zstd decoders skip over:
+
+	skippable frame magic (0x184D2A5E), table size    4 bytes each
+	for each frame: compressed size, decompressed size 4 bytes each
+	                (and a checksum, if the descriptor says so)
+	number of frames                                   4 bytes
+	descriptor (bit 7: entries have checksums)         1 byte
+	seekable magic (0x8F92EAB1)                        4 bytes
+
+all little-endian. With the table, ReadAt only has to decompress the frame(s)
+it's reading from.
+
+Writer compresses a few MB at a time into frames, several frames at
+once on different cores, and writes the table on Close. Frames are compressed
+with klauspost/compress's encoder unless you give NewWriterFunc something
+else to make them, like a function running the zstd program. Reader reads the table
+and serves ReadAt and Read, keeping the last frame it decompressed around,
+since reads tend to be near each other.
+
+*/
+
+const (
+	skippableMagic = 0x184D2A5E
+	seekableMagic  = 0x8F92EAB1
+	footerSize     = 9
+	checksumFlag   = 1 << 7
+)
+
+// how much uncompressed data goes in each frame we write, unless you ask for
+// something else
+const DefaultFrameSize = 4 << 20
+
+var ErrNotSeekable = errors.New("no seekable zstd seek table at end of file")
+
+type frame struct {
+	compressedOffs, offs int64 // where it starts, compressed and not
+	compressedSize, size int64
+}
+
+/* WRITING */
+
+type Writer struct {
+	out           io.Writer
+	compressFrame CompressFunc
+	buf           []byte
+	frameSize     int
+	queue         chan chan compressedFrame // in order
+	done          chan bool
+	entries       []byte // the seek table as we go; only writeFrames touches it
+	closed        bool
+	enc           *zstd.Encoder // NewWriter's, to close when we're done
+
+	mu  sync.Mutex
+	err error // from writing out
+}
+
+type compressedFrame struct {
+	data []byte
+	size int // uncompressed
+	err  error
+}
+
+// A CompressFunc makes one complete zstd frame out of in. Writer calls it
+// from several goroutines at once.
+type CompressFunc func(in []byte) ([]byte, error)
+
+// NewWriter starts a seekable zstd stream on out, compressed with opts, in
+// frames of frameSize bytes (0 for DefaultFrameSize).
+func NewWriter(out io.Writer, frameSize int, opts ...zstd.EOption) (*Writer, error) {
+	opts = append([]zstd.EOption{zstd.WithEncoderConcurrency(1)}, opts...)
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// EncodeAll is OK to call from several goroutines at once
+	compress := func(in []byte) ([]byte, error) {
+		return enc.EncodeAll(in, make([]byte, 0, len(in)/2)), nil
+	}
+	w := NewWriterFunc(out, frameSize, compress)
+	w.enc = enc
+	return w, nil
+}
+
+// NewWriterFunc is NewWriter with each frame made by compress.
+func NewWriterFunc(out io.Writer, frameSize int, compress CompressFunc) *Writer {
+	if frameSize == 0 {
+		frameSize = DefaultFrameSize
+	}
+	w := &Writer{
+		out:           out,
+		compressFrame: compress,
+		frameSize:     frameSize,
+		queue:         make(chan chan compressedFrame, runtime.GOMAXPROCS(0)),
+		done:          make(chan bool),
+	}
+	go w.writeFrames()
+	return w
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, errors.New("write to closed zstdseek.Writer")
+	}
+	n = len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.frameSize)
+		}
+		take := w.frameSize - len(w.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		if len(w.buf) == w.frameSize {
+			w.compress()
+		}
+	}
+	return n, w.error()
+}
+
+func (w *Writer) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// compress sends buf off to be compressed in the background
+func (w *Writer) compress() {
+	in := w.buf
+	w.buf = nil
+	result := make(chan compressedFrame, 1)
+	w.queue <- result // blocks if we're too far ahead of the output
+	go func() {
+		data, err := w.compressFrame(in)
+		result <- compressedFrame{data, len(in), err}
+	}()
+}
+
+func (w *Writer) writeFrames() {
+	for result := range w.queue {
+		f := <-result
+		if w.error() == nil {
+			err := f.err
+			if err == nil {
+				_, err = w.out.Write(f.data)
+			}
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+		}
+		var entry [8]byte
+		binary.LittleEndian.PutUint32(entry[:], uint32(len(f.data)))
+		binary.LittleEndian.PutUint32(entry[4:], uint32(f.size))
+		w.entries = append(w.entries, entry[:]...)
+	}
+	w.done <- true
+}
+
+// Close writes the last frame and the seek table. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.error()
+	}
+	w.closed = true
+	if len(w.buf) > 0 {
+		w.compress()
+	}
+	close(w.queue)
+	<-w.done
+	if w.enc != nil {
+		w.enc.Close()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	table := make([]byte, 8, 8+len(w.entries)+footerSize)
+	binary.LittleEndian.PutUint32(table, skippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(len(w.entries)+footerSize))
+	table = append(table, w.entries...)
+	var footer [footerSize]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(len(w.entries)/8))
+	footer[4] = 0 // no checksums; the frames have their own
+	binary.LittleEndian.PutUint32(footer[5:], seekableMagic)
+	table = append(table, footer[:]...)
+	_, w.err = w.out.Write(table)
+	return w.err
+}
+
+/* READING */
+
+type Reader struct {
+	ra     io.ReaderAt
+	frames []frame
+	size   int64 // uncompressed
+	dec    *zstd.Decoder
+
+	mu         sync.Mutex
+	cached     int // which frame is in buf, or -1
+	buf        []byte
+	compressed []byte
+
+	readOffs int64
+}
+
+// NewReader reads the seek table from the end of ra, which is size bytes
+// long. It returns ErrNotSeekable if there isn't one.
+func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
+	if size < footerSize+8 {
+		return nil, ErrNotSeekable
+	}
+	var footer [footerSize]byte
+	if _, err := ra.ReadAt(footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, ErrNotSeekable
+	}
+	numFrames := int64(binary.LittleEndian.Uint32(footer[:]))
+	entrySize := int64(8)
+	if footer[4]&checksumFlag != 0 {
+		entrySize = 12
+	}
+	tableSize := numFrames*entrySize + footerSize
+	if tableSize+8 > size {
+		return nil, ErrNotSeekable
+	}
+	table := make([]byte, tableSize+8)
+	if _, err := ra.ReadAt(table, size-int64(len(table))); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table) != skippableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, ErrNotSeekable
+	}
+	r := &Reader{ra: ra, cached: -1}
+	compressedOffs := int64(0)
+	for i := int64(0); i < numFrames; i++ {
+		entry := table[8+i*entrySize:]
+		f := frame{
+			compressedOffs: compressedOffs,
+			offs:           r.size,
+			compressedSize: int64(binary.LittleEndian.Uint32(entry)),
+			size:           int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		r.frames = append(r.frames, f)
+		compressedOffs += f.compressedSize
+		r.size += f.size
+	}
+	if compressedOffs+int64(len(table)) != size {
+		return nil, errors.New("seekable zstd seek table doesn't match the file's size")
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	r.dec = dec
+	return r, nil
+}
+
+// Size is how big the uncompressed content is.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// load decompresses frame i into buf, if it isn't there already; call with
+// mu held
+func (r *Reader) load(i int) error {
+	if r.cached == i {
+		return nil
+	}
+	f := r.frames[i]
+	if int64(cap(r.compressed)) < f.compressedSize {
+		r.compressed = make([]byte, f.compressedSize)
+	}
+	r.compressed = r.compressed[:f.compressedSize]
+	if _, err := r.ra.ReadAt(r.compressed, f.compressedOffs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.cached = -1
+	buf, err := r.dec.DecodeAll(r.compressed, r.buf[:0])
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) != f.size {
+		return errors.New("seekable zstd frame's size doesn't match its seek table entry")
+	}
+	r.buf, r.cached = buf, i
+	return nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := sort.Search(len(r.frames), func(i int) bool {
+		return r.frames[i].offs+r.frames[i].size > off
+	})
+	for len(p) > 0 && i < len(r.frames) {
+		if err = r.load(i); err != nil {
+			return n, err
+		}
+		c := copy(p, r.buf[off-r.frames[i].offs:])
+		p = p[c:]
+		n += c
+		off += int64(c)
+		i++
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadAt(p, r.readOffs)
+	r.readOffs += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// Close closes the underlying ReaderAt, if it's also a Closer.
+func (r *Reader) Close() error {
+	r.dec.Close()
+	if c, ok := r.ra.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Public domain, dltp contributors, 2026
+
+package zstdseek
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRoundTrip(t *testing.T) {
+	text := &bytes.Buffer{}
+	for i := 0; text.Len() < 50000; i++ {
+		fmt.Fprintf(text, "<page><id>%d</id><text>%d</text></page>\n", i, i*i)
+	}
+	want := text.Bytes()
+
+	compressed := &bytes.Buffer{}
+	w, err := NewWriter(compressed, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(want[:1234]) // writes that don't line up with frames
+	w.Write(want[1234:])
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// plain zstd decoders skip the seek table
+	dec, _ := zstd.NewReader(nil)
+	got, err := dec.DecodeAll(compressed.Bytes(), nil)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("plain decode: %v, content matches: %v", err, bytes.Equal(got, want))
+	}
+
+	ra := bytes.NewReader(compressed.Bytes())
+	r, err := NewReader(ra, ra.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(want)) || len(r.frames) != (len(want)+999)/1000 {
+		t.Fatalf("got size %d in %d frames", r.Size(), len(r.frames))
+	}
+	rng := rand.New(rand.NewSource(1)) // the same reads every run
+	for i := 0; i < 200; i++ {
+		off := rng.Intn(len(want))
+		p := make([]byte, rng.Intn(3000))
+		n, err := r.ReadAt(p, int64(off))
+		wantN := len(want) - off
+		if wantN > len(p) {
+			wantN = len(p)
+		}
+		if n != wantN || !bytes.Equal(p[:n], want[off:off+n]) {
+			t.Fatalf("ReadAt(%d bytes, %d): got %d bytes (%v), want %d", len(p), off, n, err, wantN)
+		}
+		if (err == io.EOF) != (n < len(p)) {
+			t.Fatalf("ReadAt(%d bytes, %d): err %v with %d bytes", len(p), off, err, n)
+		}
+	}
+
+	plain := &bytes.Buffer{}
+	enc, _ := zstd.NewWriter(plain)
+	enc.Write(want)
+	enc.Close()
+	ra = bytes.NewReader(plain.Bytes())
+	if _, err = NewReader(ra, ra.Size()); err != ErrNotSeekable {
+		t.Fatalf("plain zstd: got %v, want ErrNotSeekable", err)
+	}
+}
+
+func TestWriterFunc(t *testing.T) {
+	enc, _ := zstd.NewWriter(nil)
+	frames := int32(0)
+	compress := func(in []byte) ([]byte, error) {
+		atomic.AddInt32(&frames, 1) // we're called from several goroutines
+		return enc.EncodeAll(in, nil), nil
+	}
+	want := bytes.Repeat([]byte("0123456789"), 250)
+	compressed := &bytes.Buffer{}
+	w := NewWriterFunc(compressed, 1000, compress)
+	w.Write(want)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ra := bytes.NewReader(compressed.Bytes())
+	r, err := NewReader(ra, ra.Size())
+	if err != nil || r.Size() != int64(len(want)) || len(r.frames) != 3 || frames != 3 {
+		t.Fatalf("got %v, size %d in %d frames from %d calls", err, r.Size(), len(r.frames), frames)
+	}
+
+	// a frame failing fails the write
+	broken := fmt.Errorf("compressor failed")
+	w = NewWriterFunc(&bytes.Buffer{}, 1000, func(in []byte) ([]byte, error) { return nil, broken })
+	w.Write(want)
+	if err = w.Close(); err != broken {
+		t.Fatalf("Close: got %v, want the compressor's error", err)
+	}
+}